@@ -0,0 +1,85 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ConfReport is the report of checking the configuration: the files read, and the issues
+// that don't invalidate the configuration but are probably mistakes (unknown keys, empty criteria)
+type ConfReport struct {
+	Files    []string
+	Warnings []string
+}
+
+// addFile adds the config file to the report, with warnings for the keys not used by the Conf
+func (r *ConfReport) addFile(path string, meta toml.MetaData) {
+	if r == nil {
+		return
+	}
+	r.Files = append(r.Files, path)
+	for _, key := range meta.Undecoded() {
+		r.warn("unknown key %q in %v", key.String(), path)
+	}
+}
+
+// warn adds a warning to the report
+func (r *ConfReport) warn(format string, args ...any) {
+	if r == nil {
+		return
+	}
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// checkCriteria adds warnings for the empty Criterion items, that are never matched
+func (r *ConfReport) checkCriteria(name string, criteria Criteria) {
+	for i, crt := range criteria {
+		if crt.IsEmpty() && !crt.InheritDefault {
+			r.warn("%v[%d] is empty and never matches", name, i)
+		}
+	}
+}
+
+// CheckCmdConf reads the Conf the same as GetCmdConf, and returns a report of the files read
+// and the issues found in the configuration
+func CheckCmdConf(opts *CmdOptions) (*Conf, *ConfReport, error) {
+	report := new(ConfReport)
+	conf, err := getCmdConf(opts, report)
+	if err != nil {
+		return conf, report, err
+	}
+	report.checkCriteria("default", conf.Default)
+	report.checkCriteria("never_mute", conf.NeverMute)
+	for cmd, criteria := range conf.Commands {
+		report.checkCriteria(fmt.Sprintf("commands.%v", cmd), criteria)
+	}
+	for i, rule := range conf.Rules {
+		report.checkCriteria(fmt.Sprintf("rules[%d].criteria", i), rule.Criteria)
+	}
+	return conf, report, nil
+}
+
+// Redacted replaces the secrets (passwords, webhook headers and URLs) when writing the Conf
+const Redacted = "REDACTED"
+
+// WriteConf writes the Conf in TOML format, with the secrets of the notifiers redacted
+func WriteConf(w io.Writer, conf *Conf) error {
+	c := *conf
+	c.Notify = conf.Notify.redacted()
+	return toml.NewEncoder(w).Encode(&c)
+}
+
+// ConfErrorDetail returns the error message, with the position and the line of TOML syntax errors
+func ConfErrorDetail(err error) string {
+	var parseErr toml.ParseError
+	var fileErr ConfFileError
+	if errors.As(err, &parseErr) && errors.As(err, &fileErr) {
+		return fmt.Sprintf("invalid config file %v: %v", fileErr.Path, parseErr.ErrorWithPosition())
+	}
+	return err.Error()
+}
@@ -0,0 +1,74 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckCmdConf(t *testing.T) {
+	opts := &CmdOptions{ConfPath: "test/data/check.toml", ConfPathSet: true}
+	conf, report, err := CheckCmdConf(opts)
+	if err != nil {
+		t.Fatalf("CheckCmdConf want no error, got: %v", err)
+	}
+	if len(report.Files) != 1 || report.Files[0] != "test/data/check.toml" {
+		t.Errorf("CheckCmdConf want files [test/data/check.toml] got: %v", report.Files)
+	}
+	want := []string{
+		`unknown key "default.exti_codes" in test/data/check.toml`,
+		"default[0] is empty and never matches",
+	}
+	if strings.Join(report.Warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("CheckCmdConf want warnings %q got: %q", want, report.Warnings)
+	}
+
+	var buf bytes.Buffer
+	if err = WriteConf(&buf, conf); err != nil {
+		t.Fatalf("WriteConf had error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "exit_codes = [0]") || !strings.Contains(buf.String(), "inherit_default = true") {
+		t.Errorf("WriteConf want effective config, got: %v", buf.String())
+	}
+}
+
+func TestCheckCmdConfInvalid(t *testing.T) {
+	opts := &CmdOptions{ConfPath: "test/data/invalid.toml", ConfPathSet: true}
+	_, _, err := CheckCmdConf(opts)
+	if err == nil {
+		t.Fatalf("CheckCmdConf invalid want error, got nil")
+	}
+	detail := ConfErrorDetail(err)
+	if !strings.Contains(detail, "test/data/invalid.toml") || !strings.Contains(detail, "At line 2") {
+		t.Errorf("ConfErrorDetail want file name and line number, got: %v", detail)
+	}
+
+	opts = &CmdOptions{ExitCodes: "0", ConfPath: "", ConfPathSet: true}
+	if _, report, _ := CheckCmdConf(opts); len(report.Files) != 0 || len(report.Warnings) != 1 {
+		t.Errorf("CheckCmdConf options criteria want no files and a warning, got: %v", report)
+	}
+}
+
+func TestWriteConfRedacted(t *testing.T) {
+	conf := DefaultConf()
+	conf.Notify.Webhooks = []Webhook{{URL: "https://chat.example.com/hooks/cron", Headers: map[string]string{"Authorization": "Bearer token"}}}
+	conf.Notify.Emails = []Email{{To: []string{"ops@example.com"}, SMTPServer: "smtp.example.com:587",
+		SMTPUsername: "cron", SMTPPassword: "secret"}}
+	var buf bytes.Buffer
+	if err := WriteConf(&buf, conf); err != nil {
+		t.Fatalf("WriteConf had error: %v", err)
+	}
+	got := buf.String()
+	if strings.Contains(got, "Bearer token") || strings.Contains(got, "secret") || strings.Contains(got, "hooks/cron") {
+		t.Errorf("WriteConf want secrets redacted, got: %v", got)
+	}
+	if !strings.Contains(got, `Authorization = "REDACTED"`) || !strings.Contains(got, `url = "https://chat.example.com/REDACTED"`) || !strings.Contains(got, `smtp_password = "REDACTED"`) ||
+		!strings.Contains(got, `smtp_username = "cron"`) {
+		t.Errorf("WriteConf want redacted secrets with the other settings, got: %v", got)
+	}
+	if conf.Notify.Webhooks[0].Headers["Authorization"] != "Bearer token" || conf.Notify.Emails[0].SMTPPassword != "secret" {
+		t.Errorf("WriteConf should not change the Conf, got: %+v", conf.Notify)
+	}
+}
@@ -3,30 +3,355 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/farzadghanei/mute"
 )
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Version %v. Usage: %v COMMAND\n", mute.Version, os.Args[0])
-		os.Exit(mute.ExitErrExec)
+const usage = `Usage: %[1]v [OPTIONS] [--] COMMAND [COMMAND OPTIONS]
+       %[1]v check [OPTIONS]
+       %[1]v explain [OPTIONS] [EXPLAIN OPTIONS] [--] COMMAND [COMMAND OPTIONS]
+       %[1]v replay [OPTIONS] FIXTURE... [OPTIONS]
+       %[1]v history [OPTIONS] [HISTORY OPTIONS]
+
+Runs COMMAND and mutes the output under configured criteria.
+Options take precedence over environment variables, which take precedence over the config file.
+The check subcommand validates the configuration and prints the effective config.
+The explain subcommand shows how the criteria match the given results of COMMAND, without running it.
+The replay subcommand reports the recorded runs (see --record-dir) that the config mutes differently.
+The history subcommand prints the runs from the history file (see --history-file).
+
+Options:
+  -e, --exit-codes CODES        comma separated list of exit codes to mute (env: %[2]v)
+  -p, --stdout-pattern PATTERN  regex pattern to mute when stdout matches (env: %v)
+  -s, --stderr-pattern PATTERN  regex pattern to mute when stderr matches (env: %v)
+  -t, --timeout DURATION        terminate the command after timeout, e.g. 30s, 5m (env: %v)
+      --kill-grace DURATION     kill the timed out command if still running after this (env: %v)
+      --merge-streams           write stdout and stderr to stdout in the order they were received
+      --process-group           run the command in its own process group, and signal the whole group
+      --notify-recovery         print a message when a failing command succeeds and is muted again
+      --state-dir PATH          directory to store the state of previous runs (env: %v)
+      --record-dir PATH         save each run as a fixture file in this directory (env: %v)
+      --history-file PATH       append a JSON line for each run to this file (env: %v)
+      --metrics-dir PATH        write the metrics of the job to a .prom file in this directory (env: %v)
+      --job NAME                name of the job in metrics, default is the command basename
+      --format FORMAT           format of the unmuted output, raw (default) or json (env: %v)
+  -k, --state-key KEY           identify the command in the state store, default is the command line
+  -c, --config PATH             path to the config file, empty for no config file (env: %v)
+  -v, --version                 show version and exit
+  -h, --help                    show this help and exit
+
+Explain options:
+      --exit-code CODE          exit code of the command (default 0)
+      --signal SIGNAL           signal that terminated the command (no exit code)
+      --stdout-file PATH        file with the stdout of the command
+      --stderr-file PATH        file with the stderr of the command
+
+History options:
+      --command COMMAND         only the runs of the command (path or basename)
+      --since TIME              only the runs started since the time (RFC 3339, date or duration like 24h)
+      --unmuted                 only the runs that were not muted
+`
+
+// cmdArgs are the parsed command line arguments of mute
+type cmdArgs struct {
+	subcommand string // check, explain, replay or history, empty to run the command
+	opts       mute.CmdOptions
+	version    bool
+	help       bool
+	stateKey   string
+	job        string
+	cmd        string
+	cmdArgs    []string
+	// results of the command to explain
+	exitCode    int
+	exitCodeSet bool
+	signal      string
+	stdoutFile  string
+	stderrFile  string
+	// history filters
+	history mute.HistoryFilter
+	since   string
+}
+
+// subcommands of mute, the first argument
+var subcommands = []string{"check", "explain", "replay", "history"}
+
+// printUsage writes the help message to the writer
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, usage, os.Args[0], mute.EnvExitCodes, mute.EnvStdoutPattern, mute.EnvStderrPattern,
+		mute.EnvTimeout, mute.EnvKillGrace, mute.EnvStateDir, mute.EnvRecordDir, mute.EnvHistoryFile, mute.EnvMetricsDir,
+		mute.EnvOutputFormat, mute.EnvConfig)
+}
+
+// parseArgs parses mute options and the command to run from the arguments (excluding program name)
+// Subcommands are the first argument, use "--" to run a command with the same name.
+func parseArgs(args []string) (*cmdArgs, error) {
+	var parsed cmdArgs
+	if len(args) > 0 && slices.Contains(subcommands, args[0]) {
+		parsed.subcommand, args = args[0], args[1:]
+	}
+	flags := flag.NewFlagSet("mute", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	if parsed.subcommand == "explain" {
+		flags.IntVar(&parsed.exitCode, "exit-code", 0, "")
+		flags.StringVar(&parsed.signal, "signal", "", "")
+		flags.StringVar(&parsed.stdoutFile, "stdout-file", "", "")
+		flags.StringVar(&parsed.stderrFile, "stderr-file", "", "")
+	}
+	if parsed.subcommand == "history" {
+		flags.StringVar(&parsed.history.Command, "command", "", "")
+		flags.StringVar(&parsed.since, "since", "", "")
+		flags.BoolVar(&parsed.history.Unmuted, "unmuted", false, "")
+	}
+	for _, name := range []string{"e", "exit-codes"} {
+		flags.StringVar(&parsed.opts.ExitCodes, name, "", "")
+	}
+	for _, name := range []string{"p", "stdout-pattern"} {
+		flags.StringVar(&parsed.opts.StdoutPattern, name, "", "")
+	}
+	for _, name := range []string{"s", "stderr-pattern"} {
+		flags.StringVar(&parsed.opts.StderrPattern, name, "", "")
+	}
+	for _, name := range []string{"t", "timeout"} {
+		flags.StringVar(&parsed.opts.Timeout, name, "", "")
+	}
+	flags.StringVar(&parsed.opts.KillGrace, "kill-grace", "", "")
+	flags.BoolVar(&parsed.opts.MergeStreams, "merge-streams", false, "")
+	flags.BoolVar(&parsed.opts.ProcessGroup, "process-group", false, "")
+	flags.BoolVar(&parsed.opts.NotifyRecovery, "notify-recovery", false, "")
+	flags.StringVar(&parsed.opts.StateDir, "state-dir", "", "")
+	flags.StringVar(&parsed.opts.RecordDir, "record-dir", "", "")
+	flags.StringVar(&parsed.opts.HistoryFile, "history-file", "", "")
+	flags.StringVar(&parsed.opts.MetricsDir, "metrics-dir", "", "")
+	flags.StringVar(&parsed.job, "job", "", "")
+	flags.StringVar(&parsed.opts.OutputFormat, "format", "", "")
+	for _, name := range []string{"k", "state-key"} {
+		flags.StringVar(&parsed.stateKey, name, "", "")
+	}
+	for _, name := range []string{"c", "config"} {
+		flags.StringVar(&parsed.opts.ConfPath, name, "", "")
 	}
-	var target mute.Target
-	// use config file if accessible, otherwise use a default conf
-	// to mute zero exit codes
-	conf, err := mute.GetCmdConf()
+	for _, name := range []string{"v", "version"} {
+		flags.BoolVar(&parsed.version, name, false, "")
+	}
+	for _, name := range []string{"h", "help"} {
+		flags.BoolVar(&parsed.help, name, false, "")
+	}
+	if err := flags.Parse(args); err != nil {
+		return &parsed, err
+	}
+	positional := flags.Args()
+	if parsed.subcommand == "replay" { // options can follow the fixtures, e.g. replay *.json -c new.toml
+		positional = nil
+		for rest := flags.Args(); len(rest) > 0; rest = flags.Args() {
+			positional = append(positional, rest[0])
+			if len(rest) > 1 && rest[1] == "--" {
+				positional = append(positional, rest[2:]...)
+				break
+			}
+			if err := flags.Parse(rest[1:]); err != nil {
+				return &parsed, err
+			}
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "c" || f.Name == "config" {
+			parsed.opts.ConfPathSet = true
+		}
+		if f.Name == "exit-code" {
+			parsed.exitCodeSet = true
+		}
+	})
+	if len(positional) > 0 {
+		parsed.cmd = positional[0]
+		parsed.cmdArgs = positional[1:]
+	}
+	return &parsed, nil
+}
+
+// checkConf validates the configuration, prints the files read, the warnings and the effective config.
+// Returns the exit code, ExitErrConf when the configuration is invalid.
+func checkConf(args *cmdArgs, stdout, stderr io.Writer) int {
+	if args.cmd != "" {
+		fmt.Fprintf(stderr, "check does not run a command, got: %v\n", args.cmd)
+		return mute.ExitErrConf
+	}
+	conf, report, err := mute.CheckCmdConf(&args.opts)
+	for _, path := range report.Files {
+		fmt.Fprintf(stderr, "config file: %v\n", path)
+	}
+	for _, warning := range report.Warnings {
+		fmt.Fprintf(stderr, "warning: %v\n", warning)
+	}
+	if err != nil {
+		if _, ok := err.(mute.ConfAccessError); !ok {
+			fmt.Fprintf(stderr, "config error: %v\n", mute.ConfErrorDetail(err))
+			return mute.ExitErrConf
+		}
+		fmt.Fprintf(stderr, "warning: %v, using the default config\n", err)
+		defaultConf := mute.DefaultConf()
+		defaultConf.Settings = conf.Settings
+		conf = defaultConf
+	}
+	if err = mute.WriteConf(stdout, conf); err != nil {
+		fmt.Fprintf(stderr, "config error: %v\n", err)
+		return mute.ExitErrConf
+	}
+	return 0
+}
+
+// loadConf returns the Conf from options, env vars and config file, or the default conf
+// (to mute zero exit codes) when the config file is not accessible. Exits on invalid config.
+func loadConf(args *cmdArgs) *mute.Conf {
+	conf, err := mute.GetCmdConf(&args.opts)
 	if err != nil {
 		if _, ok := err.(mute.ConfAccessError); ok {
-			conf = mute.DefaultConf()
+			defaultConf := mute.DefaultConf()
+			defaultConf.Settings = conf.Settings // keep settings from options/env vars
+			conf = defaultConf
 		} else {
-			fmt.Fprintf(os.Stderr, "config error:  %v", err)
+			fmt.Fprintf(os.Stderr, "config error: %v\n", err)
 			os.Exit(mute.ExitErrConf)
 		}
 	}
-	target = mute.Target{Cmd: os.Args[1], Args: os.Args[2:], Conf: conf, OutWriter: os.Stdout, ErrWriter: os.Stderr, BufPreAlloc: 4096}
+	return conf
+}
+
+// explain prints how the criteria match the results of the command, without running it
+// Returns the exit code, ExitErrConf when the results can not be read.
+func explain(args *cmdArgs, conf *mute.Conf, stdout, stderr io.Writer) int {
+	result := &mute.Result{Cmd: args.cmd, Args: args.cmdArgs, ExitCode: args.exitCode}
+	if args.signal != "" {
+		sig, err := mute.ParseSignal(args.signal)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return mute.ExitErrConf
+		}
+		if args.exitCodeSet {
+			fmt.Fprintf(stderr, "--exit-code and --signal can not be used together, terminated commands have no exit code\n")
+			return mute.ExitErrConf
+		}
+		result.Signal, result.ExitCode = sig, -1 // same as a terminated command
+	}
+	for _, output := range []struct {
+		path string
+		dest *string
+	}{{args.stdoutFile, &result.Stdout}, {args.stderrFile, &result.Stderr}} {
+		if output.path == "" {
+			continue
+		}
+		content, err := os.ReadFile(output.path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return mute.ExitErrConf
+		}
+		*output.dest = string(content)
+	}
+	_, _ = conf.Explain(result).WriteTo(stdout)
+	return 0
+}
+
+// replay evaluates the recorded runs (fixture files) against the config, and prints the runs
+// with a different mute decision. Returns 1 if any decision changed, ExitErrConf if fixtures can not be read.
+func replay(paths []string, conf *mute.Conf, stdout, stderr io.Writer) int {
+	changed := 0
+	for _, path := range paths {
+		fixture, err := mute.ReadFixture(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return mute.ExitErrConf
+		}
+		exp, diff := conf.Replay(fixture)
+		if !diff {
+			continue
+		}
+		changed++
+		fmt.Fprintf(stdout, "%v: %v: %v -> %v (%v)\n", path, strings.Join(append([]string{fixture.Cmd}, fixture.Args...), " "),
+			muteDecision(fixture.Muted), muteDecision(exp.Muted), exp.Reason)
+	}
+	fmt.Fprintf(stdout, "replayed %d runs, %d changed\n", len(paths), changed)
+	if changed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// history prints the runs from the history file selected by the filters
+// Returns the exit code, ExitErrConf if the history file is not set or can not be read.
+func history(args *cmdArgs, conf *mute.Conf, stdout, stderr io.Writer) int {
+	if conf.Settings.HistoryFile == "" {
+		fmt.Fprintf(stderr, "history file is not set, use --history-file or %v\n", mute.EnvHistoryFile)
+		return mute.ExitErrConf
+	}
+	if args.since != "" {
+		since, err := mute.ParseSince(args.since, time.Now())
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return mute.ExitErrConf
+		}
+		args.history.Since = since
+	}
+	entries, err := mute.ReadHistory(conf.Settings.HistoryFile, &args.history)
+	for _, entry := range entries {
+		fmt.Fprintln(stdout, entry.String())
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return mute.ExitErrConf
+	}
+	return 0
+}
+
+// muteDecision returns the mute decision as text
+func muteDecision(muted bool) string {
+	if muted {
+		return "muted"
+	}
+	return "not muted"
+}
+
+func main() {
+	args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		printUsage(os.Stderr)
+		os.Exit(mute.ExitErrConf)
+	}
+	if args.help {
+		printUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if args.version {
+		fmt.Fprintf(os.Stdout, "mute %v\n", mute.Version)
+		os.Exit(0)
+	}
+	if args.subcommand == "check" {
+		os.Exit(checkConf(args, os.Stdout, os.Stderr))
+	}
+	if args.subcommand == "history" {
+		os.Exit(history(args, loadConf(args), os.Stdout, os.Stderr))
+	}
+	if args.cmd == "" {
+		fmt.Fprintf(os.Stderr, "Version %v. ", mute.Version)
+		printUsage(os.Stderr)
+		os.Exit(mute.ExitErrExec)
+	}
+	conf := loadConf(args)
+	if args.subcommand == "explain" {
+		os.Exit(explain(args, conf, os.Stdout, os.Stderr))
+	}
+	if args.subcommand == "replay" {
+		os.Exit(replay(append([]string{args.cmd}, args.cmdArgs...), conf, os.Stdout, os.Stderr))
+	}
+	target := mute.Target{Cmd: args.cmd, Args: args.cmdArgs, Conf: conf, OutWriter: os.Stdout, ErrWriter: os.Stderr, BufPreAlloc: 4096,
+		StateKey: args.stateKey, Job: args.job}
 	exitCode, _ := target.Exec()
 	os.Exit(exitCode)
 }
@@ -4,11 +4,17 @@
 package mute
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -25,10 +31,43 @@ const EnvExitCodes string = "MUTE_EXIT_CODES"
 // EnvStdoutPattern is the name of the environment variable to overwrite stdout regex pattern
 const EnvStdoutPattern string = "MUTE_STDOUT_PATTERN"
 
+// EnvStderrPattern is the name of the environment variable to overwrite stderr regex pattern
+const EnvStderrPattern string = "MUTE_STDERR_PATTERN"
+
+// EnvTimeout is the name of the environment variable to overwrite the execution timeout
+const EnvTimeout string = "MUTE_TIMEOUT"
+
+// EnvStateDir is the name of the environment variable to overwrite the directory to store the state of runs
+const EnvStateDir string = "MUTE_STATE_DIR"
+
+// EnvRecordDir is the name of the environment variable to overwrite the directory to record runs as fixtures
+const EnvRecordDir string = "MUTE_RECORD_DIR"
+
+// EnvHistoryFile is the name of the environment variable to overwrite the file to append the history of runs
+const EnvHistoryFile string = "MUTE_HISTORY_FILE"
+
+// EnvMetricsDir is the name of the environment variable to overwrite the directory to write the metrics of runs
+const EnvMetricsDir string = "MUTE_METRICS_DIR"
+
+// EnvOutputFormat is the name of the environment variable to overwrite the format of the unmuted output
+const EnvOutputFormat string = "MUTE_OUTPUT_FORMAT"
+
+// EnvKillGrace is the name of the environment variable to overwrite the grace period before killing timed out commands
+const EnvKillGrace string = "MUTE_KILL_GRACE"
+
+// DefaultConfPath is the path of the system config file, the snippets in /etc/mute.d are read after it
+const DefaultConfPath string = "/etc/mute.toml"
+
+// systemConfPath is the config file read when not set by options or env vars, a var to replace in tests
+var systemConfPath = DefaultConfPath
+
 // ExitErrConf is exit code when config is invalid
 const ExitErrConf = 126
 
-// StdoutPattern hold regex pattern to match stdout with
+// DefaultKillGrace is the time to wait after terminating a timed out command before killing it
+const DefaultKillGrace = 5 * time.Second
+
+// StdoutPattern hold regex pattern to match stdout (or stderr) with
 type StdoutPattern struct {
 	Regexp *regexp.Regexp
 }
@@ -41,8 +80,16 @@ func (s *StdoutPattern) UnmarshalText(text []byte) error {
 	return err
 }
 
+// MarshalText writes the regex pattern
+func (s *StdoutPattern) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 // String return the regex pattern string
 func (s *StdoutPattern) String() string {
+	if s == nil || s.Regexp == nil {
+		return ""
+	}
 	return s.Regexp.String()
 }
 
@@ -54,10 +101,29 @@ func NewStdoutPattern(pattern string) *StdoutPattern {
 	return &stdp
 }
 
-// Criterion is expected exit codes and stdout patterns to mute a process
+// Criterion is expected exit codes, signals and stdout/stderr patterns to mute a process
+// Exclude patterns veto the Criterion, when stdout/stderr matches any of them.
 type Criterion struct {
-	ExitCodes      []int            `toml:"exit_codes"`
-	StdoutPatterns []*StdoutPattern `toml:"stdout_patterns"`
+	ExitCodes             []int            `toml:"exit_codes,omitempty"`
+	Signals               []Signal         `toml:"signals,omitempty"`
+	StdoutPatterns        []*StdoutPattern `toml:"stdout_patterns,omitempty"`
+	StderrPatterns        []*StdoutPattern `toml:"stderr_patterns,omitempty"`
+	StdoutExcludePatterns []*StdoutPattern `toml:"stdout_exclude_patterns,omitempty"`
+	StderrExcludePatterns []*StdoutPattern `toml:"stderr_exclude_patterns,omitempty"`
+	// stop matching when the process failed this many times in a row (including the current run)
+	UnmuteAfterConsecutiveFailures int `toml:"unmute_after_consecutive_failures,omitzero"`
+	// match only when the output is the same as the previous run (see Settings.OutputSubstitutions)
+	MuteIfUnchanged bool `toml:"mute_if_unchanged,omitempty"`
+	// with MuteIfUnchanged, stop matching the unchanged output if it was not printed for this long
+	RealertAfter Duration `toml:"realert_after,omitzero"`
+	// extend the default criteria instead of replacing them, when set on command (or rule) criteria
+	InheritDefault bool `toml:"inherit_default,omitempty"`
+}
+
+// Substitution replaces the matches of a regex pattern, used to normalize the output
+type Substitution struct {
+	Pattern *StdoutPattern `toml:"pattern,omitempty"`
+	Replace string         `toml:"replace"`
 }
 
 // Criterion.String return a string desc to help debugging and inspecting data
@@ -74,16 +140,127 @@ func (c *Criterion) String() string {
 
 // IsEmpty checks if a Criterion is empty (no exit codes, no patterns)
 func (c *Criterion) IsEmpty() bool {
-	return len(c.ExitCodes) < 1 && len(c.StdoutPatterns) < 1
+	return len(c.ExitCodes) < 1 && len(c.Signals) < 1 && len(c.StdoutPatterns) < 1 && len(c.StderrPatterns) < 1 &&
+		len(c.StdoutExcludePatterns) < 1 && len(c.StderrExcludePatterns) < 1 && !c.usesState()
+}
+
+// usesState checks if matching the Criterion requires the state of the previous runs
+func (c *Criterion) usesState() bool {
+	return c.UnmuteAfterConsecutiveFailures > 0 || c.MuteIfUnchanged
 }
 
 // Criteria is a list of Criterion that if a process matched any of, it'll be muted
 type Criteria []*Criterion
 
+// Rule selects the Criteria for commands matching all of its set selectors:
+// the command by regex, glob or basename, and the arguments (joined by space) by regex.
+// A glob without a path separator is matched against the basename of the command.
+type Rule struct {
+	CommandRegex *StdoutPattern `toml:"command_regex,omitempty"`
+	CommandGlob  string         `toml:"command_glob,omitempty"`
+	Basename     string         `toml:"basename,omitempty"`
+	ArgsRegex    *StdoutPattern `toml:"args_regex,omitempty"`
+	Criteria     Criteria       `toml:"criteria,omitempty"`
+}
+
+// validate checks if the Rule has at least one selector, and the glob is valid
+func (r *Rule) validate() error {
+	if r.CommandRegex == nil && r.CommandGlob == "" && r.Basename == "" && r.ArgsRegex == nil {
+		return errors.New("rule has no command_regex, command_glob, basename or args_regex")
+	}
+	if _, err := filepath.Match(r.CommandGlob, ""); err != nil {
+		return fmt.Errorf("invalid command_glob %q: %w", r.CommandGlob, err)
+	}
+	return nil
+}
+
+// matches checks if the command and its arguments match all the set selectors of the Rule
+func (r *Rule) matches(cmd string, args []string) bool {
+	if r.CommandRegex != nil && !r.CommandRegex.Regexp.MatchString(cmd) {
+		return false
+	}
+	if r.CommandGlob != "" {
+		name := cmd
+		if !strings.Contains(r.CommandGlob, "/") {
+			name = filepath.Base(cmd)
+		}
+		if matched, _ := filepath.Match(r.CommandGlob, name); !matched {
+			return false
+		}
+	}
+	if r.Basename != "" && r.Basename != filepath.Base(cmd) {
+		return false
+	}
+	if r.ArgsRegex != nil && !r.ArgsRegex.Regexp.MatchString(strings.Join(args, " ")) {
+		return false
+	}
+	return true
+}
+
+// equal checks if the Rules have the same selectors and Criteria
+func (r *Rule) equal(r2 *Rule) bool {
+	return r.CommandRegex.String() == r2.CommandRegex.String() && r.CommandGlob == r2.CommandGlob &&
+		r.Basename == r2.Basename && r.ArgsRegex.String() == r2.ArgsRegex.String() && r.Criteria.equal(&r2.Criteria)
+}
+
+// Settings are the execution options of the processes (other than criteria)
+// Zero values mean not set, so the global settings or defaults are used, unless set explicitly
+// by the config file, options or env vars (e.g. to disable a timeout set by an earlier config file).
+type Settings struct {
+	// terminate the process after this duration, zero for no timeout
+	Timeout Duration `toml:"timeout,omitzero"`
+	// wait this long after terminating the timed out (or canceled) process before killing it
+	KillGrace Duration `toml:"kill_grace,omitzero"`
+	// keep up to this many bytes of each output stream in memory, spill the rest to a temp file. zero for no limit
+	MaxBufferBytes int64 `toml:"max_buffer_bytes,omitzero"`
+	// instead of spilling to a file, keep the head and the tail of the output and discard the middle
+	TruncateOutput bool `toml:"truncate_output,omitempty"`
+	// write stdout and stderr in the order they were received from the process
+	OrderedOutput bool `toml:"ordered_output,omitempty"`
+	// write both stdout and stderr to stdout in the order they were received (implies ordered output)
+	MergeStreams bool `toml:"merge_streams,omitempty"`
+	// run the process in its own process group, and send signals to the whole group
+	ProcessGroup bool `toml:"process_group,omitempty"`
+	// directory to store the state of the previous runs, see DefaultStateDir
+	StateDir string `toml:"state_dir,omitempty"`
+	// command criteria extend the default criteria instead of replacing them (see Criterion.InheritDefault)
+	CommandsInheritDefault bool `toml:"commands_inherit_default,omitempty"`
+	// print a message when a failing process succeeds and is muted again, after the previous run was not muted
+	NotifyRecovery bool `toml:"notify_recovery,omitempty"`
+	// save each run (command, results, output and the mute decision) as a fixture file in this directory
+	RecordDir string `toml:"record_dir,omitempty"`
+	// append a JSON line for each run to this file
+	HistoryFile string `toml:"history_file,omitempty"`
+	// rotate the history file before exceeding this size, see DefaultHistoryMaxBytes. negative for no rotation
+	HistoryMaxBytes int64 `toml:"history_max_bytes,omitzero"`
+	// number of rotated history files to keep, see DefaultHistoryKeep
+	HistoryKeep int `toml:"history_keep,omitzero"`
+	// write the metrics of the last run of each job to a .prom file in this directory (Prometheus textfile format)
+	MetricsDir string `toml:"metrics_dir,omitempty"`
+	// write the unmuted output only to the notifiers (see Conf.Notify), not to stdout/stderr, if there are any
+	NotifyOnly bool `toml:"notify_only,omitempty"`
+	// format of the unmuted output, OutputFormatRaw (default) or OutputFormatJSON
+	OutputFormat string `toml:"output_format,omitempty"`
+	// normalize the output before comparing it with the previous run, applied to each line in order
+	OutputSubstitutions []Substitution `toml:"output_substitutions,omitempty"`
+	// keys of the settings set explicitly, so their zero values override when merged
+	defined map[string]bool
+}
+
 // Conf is the mute configuration of default and per process criteria
+// Rules are checked in order before Commands, the first matching Rule selects the criteria.
+// NeverMute criteria override all others, a process matching any of them is never muted.
+// Settings apply to all processes, CommandSettings override them per process.
+// Notify configures the notifiers of unmuted runs.
 type Conf struct {
-	Default  Criteria
-	Commands map[string]Criteria
+	Default         Criteria            `toml:"default,omitempty"`
+	Commands        map[string]Criteria `toml:"commands,omitempty"`
+	Rules           []Rule              `toml:"rules,omitempty"`
+	Include         []string            `toml:"include,omitempty"`
+	NeverMute       Criteria            `toml:"never_mute,omitempty"`
+	Settings        Settings            `toml:"settings,omitempty"`
+	CommandSettings map[string]Settings `toml:"command_settings,omitempty"`
+	Notify          Notify              `toml:"notify,omitempty"`
 }
 
 // ConfAccessError represents errors when accessing to Config files
@@ -92,6 +269,12 @@ type ConfAccessError struct {
 	Path string
 }
 
+// ConfFileError represents errors in the content of Config files (invalid TOML, rules or includes)
+type ConfFileError struct {
+	err  error
+	Path string
+}
+
 // NewCriterion returns pointer to Criterion with specified exit codes and regex patterns from strings
 func NewCriterion(codes []int, patterns []string) *Criterion {
 	c := new(Criterion)
@@ -104,6 +287,38 @@ func NewCriterion(codes []int, patterns []string) *Criterion {
 	return c
 }
 
+// AddSignals adds signals that terminated the process to the Criterion, and returns the Criterion
+func (c *Criterion) AddSignals(signals ...syscall.Signal) *Criterion {
+	for _, sig := range signals {
+		c.Signals = append(c.Signals, Signal(sig))
+	}
+	return c
+}
+
+// AddStderrPatterns adds regex patterns from strings to match stderr, and returns the Criterion
+func (c *Criterion) AddStderrPatterns(patterns ...string) *Criterion {
+	for _, p := range patterns {
+		c.StderrPatterns = append(c.StderrPatterns, NewStdoutPattern(p))
+	}
+	return c
+}
+
+// AddStdoutExcludePatterns adds regex patterns from strings to veto the Criterion when stdout matches
+func (c *Criterion) AddStdoutExcludePatterns(patterns ...string) *Criterion {
+	for _, p := range patterns {
+		c.StdoutExcludePatterns = append(c.StdoutExcludePatterns, NewStdoutPattern(p))
+	}
+	return c
+}
+
+// AddStderrExcludePatterns adds regex patterns from strings to veto the Criterion when stderr matches
+func (c *Criterion) AddStderrExcludePatterns(patterns ...string) *Criterion {
+	for _, p := range patterns {
+		c.StderrExcludePatterns = append(c.StderrExcludePatterns, NewStdoutPattern(p))
+	}
+	return c
+}
+
 // DefaultConf returns a Conf to use when there is no conf file to read
 // It's a Conf with a Default Criteria to mute only successful
 // runs (zero exit code)
@@ -142,24 +357,86 @@ func stdoutPatternsContain(haystack []*StdoutPattern, needle *StdoutPattern) boo
 	return false
 }
 
-// Criterion.equal returns true of Criterions have the same exit codes and stdout patterns
+// Criterion.equal returns true of Criterions have the same exit codes and stdout/stderr patterns
 func (c *Criterion) equal(c2 *Criterion) bool {
 	if len(c.ExitCodes) != len(c2.ExitCodes) || len(c.StdoutPatterns) != len(c2.StdoutPatterns) {
 		return false
 	}
+	if len(c.StderrPatterns) != len(c2.StderrPatterns) || len(c.Signals) != len(c2.Signals) {
+		return false
+	}
+	if c.UnmuteAfterConsecutiveFailures != c2.UnmuteAfterConsecutiveFailures ||
+		c.MuteIfUnchanged != c2.MuteIfUnchanged || c.RealertAfter != c2.RealertAfter ||
+		c.InheritDefault != c2.InheritDefault {
+		return false
+	}
+	if len(c.StdoutExcludePatterns) != len(c2.StdoutExcludePatterns) ||
+		len(c.StderrExcludePatterns) != len(c2.StderrExcludePatterns) {
+		return false
+	}
 	for _, code := range c.ExitCodes {
 		if !codesContain(c2.ExitCodes, code) {
 			return false
 		}
 	}
+	for _, sig := range c.Signals {
+		if !signalsContain(c2.Signals, syscall.Signal(sig)) {
+			return false
+		}
+	}
 	for _, pattern := range c.StdoutPatterns {
 		if !stdoutPatternsContain(c2.StdoutPatterns, pattern) {
 			return false
 		}
 	}
+	for _, pattern := range c.StderrPatterns {
+		if !stdoutPatternsContain(c2.StderrPatterns, pattern) {
+			return false
+		}
+	}
+	for _, pattern := range c.StdoutExcludePatterns {
+		if !stdoutPatternsContain(c2.StdoutExcludePatterns, pattern) {
+			return false
+		}
+	}
+	for _, pattern := range c.StderrExcludePatterns {
+		if !stdoutPatternsContain(c2.StderrExcludePatterns, pattern) {
+			return false
+		}
+	}
 	return true
 }
 
+// Criteria.usesState checks if matching any of the Criterion requires the state of the previous runs
+func (c *Criteria) usesState() bool {
+	for _, item := range *c {
+		if item.usesState() {
+			return true
+		}
+	}
+	return false
+}
+
+// Criteria.mutesUnchanged checks if any of the Criterion compares the output with the previous run
+func (c *Criteria) mutesUnchanged() bool {
+	for _, item := range *c {
+		if item.MuteIfUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// Criteria.inheritsDefault checks if any of the Criterion extends the default criteria
+func (c *Criteria) inheritsDefault() bool {
+	for _, item := range *c {
+		if item.InheritDefault {
+			return true
+		}
+	}
+	return false
+}
+
 // Criteria.contains check if the criteria contains a given criterion
 func (c *Criteria) contains(criterion *Criterion) bool {
 	for _, item := range *c {
@@ -191,6 +468,22 @@ func (c *Conf) equal(c2 *Conf) bool {
 	if !c.Default.equal(&(c2.Default)) {
 		return false
 	}
+	if !c.NeverMute.equal(&(c2.NeverMute)) || len(c.Rules) != len(c2.Rules) {
+		return false
+	}
+	for i := range c.Rules {
+		if !c.Rules[i].equal(&c2.Rules[i]) {
+			return false
+		}
+	}
+	if !c.Notify.equal(&c2.Notify) || !c.Settings.equal(&c2.Settings) || len(c.CommandSettings) != len(c2.CommandSettings) {
+		return false
+	}
+	for cmd, settings := range c.CommandSettings {
+		if settings2, ok := c2.CommandSettings[cmd]; !ok || !settings.equal(&settings2) {
+			return false
+		}
+	}
 	if len(c.Commands) != len(c2.Commands) {
 		return false
 	}
@@ -205,33 +498,349 @@ func (c *Conf) equal(c2 *Conf) bool {
 	return true
 }
 
+// Settings.merge overrides settings with the values set in another Settings,
+// the non zero values, and the zero values that are set explicitly (e.g. timeout = 0 in a later config file)
+func (s *Settings) merge(s2 *Settings) *Settings {
+	if s2.isSet("timeout", s2.Timeout != 0) {
+		s.Timeout = s2.Timeout
+	}
+	if s2.isSet("kill_grace", s2.KillGrace != 0) {
+		s.KillGrace = s2.KillGrace
+	}
+	if s2.isSet("max_buffer_bytes", s2.MaxBufferBytes != 0) {
+		s.MaxBufferBytes = s2.MaxBufferBytes
+	}
+	if s2.isSet("truncate_output", s2.TruncateOutput) {
+		s.TruncateOutput = s2.TruncateOutput
+	}
+	if s2.isSet("ordered_output", s2.OrderedOutput) {
+		s.OrderedOutput = s2.OrderedOutput
+	}
+	if s2.isSet("merge_streams", s2.MergeStreams) {
+		s.MergeStreams = s2.MergeStreams
+	}
+	if s2.isSet("process_group", s2.ProcessGroup) {
+		s.ProcessGroup = s2.ProcessGroup
+	}
+	if s2.isSet("notify_recovery", s2.NotifyRecovery) {
+		s.NotifyRecovery = s2.NotifyRecovery
+	}
+	if s2.isSet("notify_only", s2.NotifyOnly) {
+		s.NotifyOnly = s2.NotifyOnly
+	}
+	if s2.isSet("output_format", s2.OutputFormat != "") {
+		s.OutputFormat = s2.OutputFormat
+	}
+	if s2.isSet("commands_inherit_default", s2.CommandsInheritDefault) {
+		s.CommandsInheritDefault = s2.CommandsInheritDefault
+	}
+	if s2.isSet("state_dir", s2.StateDir != "") {
+		s.StateDir = s2.StateDir
+	}
+	if s2.isSet("record_dir", s2.RecordDir != "") {
+		s.RecordDir = s2.RecordDir
+	}
+	if s2.isSet("history_file", s2.HistoryFile != "") {
+		s.HistoryFile = s2.HistoryFile
+	}
+	if s2.isSet("history_max_bytes", s2.HistoryMaxBytes != 0) {
+		s.HistoryMaxBytes = s2.HistoryMaxBytes
+	}
+	if s2.isSet("history_keep", s2.HistoryKeep != 0) {
+		s.HistoryKeep = s2.HistoryKeep
+	}
+	if s2.isSet("metrics_dir", s2.MetricsDir != "") {
+		s.MetricsDir = s2.MetricsDir
+	}
+	if s2.isSet("output_substitutions", len(s2.OutputSubstitutions) > 0) {
+		s.OutputSubstitutions = s2.OutputSubstitutions
+	}
+	s.define(slices.Collect(maps.Keys(s2.defined))...)
+	return s
+}
+
+// isSet checks if the setting of the key is set, by a non zero value or explicitly (even to a zero value)
+func (s *Settings) isSet(key string, nonZero bool) bool {
+	return nonZero || s.defined[key]
+}
+
+// define marks the keys as explicitly set. The keys are copied, as copies of the Settings share them.
+func (s *Settings) define(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	defined := maps.Clone(s.defined)
+	if defined == nil {
+		defined = make(map[string]bool, len(keys))
+	}
+	for _, key := range keys {
+		defined[key] = true
+	}
+	s.defined = defined
+}
+
+// undefine marks the key as not set, for the zero value of the setting to not override when merged
+func (s *Settings) undefine(key string) {
+	if s.defined[key] {
+		s.defined = maps.Clone(s.defined)
+		delete(s.defined, key)
+	}
+}
+
+// Settings.validate checks the values of the Settings
+func (s *Settings) validate() error {
+	if s.OutputFormat != "" && s.OutputFormat != OutputFormatRaw && s.OutputFormat != OutputFormatJSON {
+		return fmt.Errorf("invalid output format %q, should be %v or %v", s.OutputFormat, OutputFormatRaw, OutputFormatJSON)
+	}
+	return nil
+}
+
+// Settings.equal checks if the Settings have the same values
+func (s *Settings) equal(s2 *Settings) bool {
+	subs, subs2 := s.OutputSubstitutions, s2.OutputSubstitutions
+	if len(subs) != len(subs2) {
+		return false
+	}
+	for i := range subs {
+		if subs[i].Pattern.String() != subs2[i].Pattern.String() || subs[i].Replace != subs2[i].Replace {
+			return false
+		}
+	}
+	return s.Timeout == s2.Timeout && s.KillGrace == s2.KillGrace && s.MaxBufferBytes == s2.MaxBufferBytes &&
+		s.TruncateOutput == s2.TruncateOutput && s.OrderedOutput == s2.OrderedOutput &&
+		s.MergeStreams == s2.MergeStreams && s.ProcessGroup == s2.ProcessGroup && s.StateDir == s2.StateDir &&
+		s.NotifyRecovery == s2.NotifyRecovery && s.NotifyOnly == s2.NotifyOnly && s.OutputFormat == s2.OutputFormat && s.CommandsInheritDefault == s2.CommandsInheritDefault &&
+		s.RecordDir == s2.RecordDir && s.HistoryFile == s2.HistoryFile && s.HistoryMaxBytes == s2.HistoryMaxBytes &&
+		s.HistoryKeep == s2.HistoryKeep && s.MetricsDir == s2.MetricsDir
+}
+
+// Conf.override overrides the global settings with the values set in Settings,
+// including the command specific settings so the overridden values apply to all commands
+func (c *Conf) override(s *Settings) {
+	c.Settings.merge(s)
+	for cmd, cmdSettings := range c.CommandSettings {
+		if s.isSet("timeout", s.Timeout != 0) {
+			cmdSettings.Timeout = 0
+			cmdSettings.undefine("timeout")
+		}
+		if s.isSet("kill_grace", s.KillGrace != 0) {
+			cmdSettings.KillGrace = 0
+			cmdSettings.undefine("kill_grace")
+		}
+		if s.StateDir != "" {
+			cmdSettings.StateDir = ""
+		}
+		if s.RecordDir != "" {
+			cmdSettings.RecordDir = ""
+		}
+		if s.HistoryFile != "" {
+			cmdSettings.HistoryFile = ""
+		}
+		if s.MetricsDir != "" {
+			cmdSettings.MetricsDir = ""
+		}
+		if s.OutputFormat != "" {
+			cmdSettings.OutputFormat = ""
+		}
+		c.CommandSettings[cmd] = cmdSettings
+	}
+}
+
 // IsEmpty determines if the Conf is empty
 func (c *Conf) IsEmpty() bool {
-	return len(c.Default) < 1 && len(c.Commands) < 1
+	return len(c.Default) < 1 && len(c.Commands) < 1 && len(c.NeverMute) < 1 && len(c.Rules) < 1
+}
+
+// Conf.merge merges the Conf read from a later config file into the Conf.
+// Default, NeverMute criteria, Rules and notifiers are appended, criteria of the same command are replaced,
+// and settings (global and per command) are overridden by the values set in c2.
+func (c *Conf) merge(c2 *Conf) *Conf {
+	c.Default = append(c.Default, c2.Default...)
+	c.NeverMute = append(c.NeverMute, c2.NeverMute...)
+	c.Rules = append(c.Rules, c2.Rules...)
+	c.Notify.merge(&c2.Notify)
+	for cmd, criteria := range c2.Commands {
+		if c.Commands == nil {
+			c.Commands = make(map[string]Criteria)
+		}
+		c.Commands[cmd] = criteria
+	}
+	c.Settings.merge(&c2.Settings)
+	for cmd, settings := range c2.CommandSettings {
+		if c.CommandSettings == nil {
+			c.CommandSettings = make(map[string]Settings)
+		}
+		cmdSettings := c.CommandSettings[cmd]
+		c.CommandSettings[cmd] = *cmdSettings.merge(&settings)
+	}
+	return c
 }
 
 func (e ConfAccessError) Error() string {
-	return e.err.Error()
+	err := e.err
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) { // path is already in the message
+		err = pathErr.Err
+	}
+	return fmt.Sprintf("can not access config file %v: %v", e.Path, err)
 }
 
-// ReadConfFile reads config file and returns Conf
+// Unwrap returns the underlying error
+func (e ConfAccessError) Unwrap() error {
+	return e.err
+}
+
+func (e ConfFileError) Error() string {
+	return fmt.Sprintf("invalid config file %v: %v", e.Path, e.err)
+}
+
+// Unwrap returns the underlying error
+func (e ConfFileError) Unwrap() error {
+	return e.err
+}
+
+// ReadConfFile reads config file and the files it includes, and returns Conf
+// Included files (relative to the including file, globs allowed) are merged after the file.
 func ReadConfFile(path string) (*Conf, error) {
+	return readConfFile(path, make(map[string]bool), nil)
+}
+
+// readConfFile reads config file and its includes, reading files not already in the include chain
+// The read files and their issues are added to the report, if not nil.
+func readConfFile(path string, reading map[string]bool, report *ConfReport) (*Conf, error) {
 	var conf Conf
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return &conf, ConfAccessError{err: err, Path: path}
 	}
 	contentStr := string(content)
-	_, err = toml.Decode(contentStr, &conf)
-	return &conf, err
+	meta, err := toml.Decode(contentStr, &conf)
+	if err != nil {
+		return &conf, ConfFileError{err: err, Path: path}
+	}
+	report.addFile(path, meta)
+	for _, key := range meta.Keys() { // the set keys, to override with zero values when merged
+		switch {
+		case len(key) == 2 && key[0] == "settings":
+			conf.Settings.define(key[1])
+		case len(key) == 3 && key[0] == "command_settings":
+			settings := conf.CommandSettings[key[1]]
+			settings.define(key[2])
+			conf.CommandSettings[key[1]] = settings
+		}
+	}
+	for i := range conf.Rules {
+		if err = conf.Rules[i].validate(); err != nil {
+			return &conf, ConfFileError{err: fmt.Errorf("invalid rules[%d]: %w", i, err), Path: path}
+		}
+	}
+	if err = conf.Notify.validate(); err != nil {
+		return &conf, ConfFileError{err: err, Path: path}
+	}
+	if err = conf.Settings.validate(); err != nil {
+		return &conf, ConfFileError{err: fmt.Errorf("invalid settings: %w", err), Path: path}
+	}
+	for cmd, settings := range conf.CommandSettings {
+		if err = settings.validate(); err != nil {
+			return &conf, ConfFileError{err: fmt.Errorf("invalid command_settings.%v: %w", cmd, err), Path: path}
+		}
+	}
+	absPath, _ := filepath.Abs(path)
+	reading[absPath] = true
+	defer delete(reading, absPath)
+	for _, include := range conf.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		paths, err := filepath.Glob(include)
+		if err != nil {
+			return &conf, ConfFileError{err: fmt.Errorf("invalid include %q: %w", include, err), Path: path}
+		}
+		if len(paths) == 0 && !strings.ContainsAny(include, "*?[") {
+			paths = []string{include} // report the missing file
+		}
+		for _, incPath := range paths {
+			if absInc, _ := filepath.Abs(incPath); reading[absInc] {
+				return &conf, ConfFileError{err: fmt.Errorf("include cycle with %v", incPath), Path: path}
+			}
+			included, err := readConfFile(incPath, reading, report)
+			if _, ok := err.(ConfAccessError); ok {
+				return &conf, ConfFileError{err: err, Path: path}
+			}
+			if err != nil {
+				return &conf, err
+			}
+			conf.merge(included)
+		}
+	}
+	return &conf, nil
+}
+
+// UserConfPath returns the path of the user config file, $XDG_CONFIG_HOME/mute/config.toml
+// or ~/.config/mute/config.toml, or empty string if the home directory is unknown
+func UserConfPath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "mute", "config.toml")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "mute", "config.toml")
+	}
+	return ""
+}
+
+// ConfDropInDir returns the directory of config snippets of the config file, /etc/mute.d for /etc/mute.toml
+func ConfDropInDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".d"
+}
+
+// ReadConfLayers reads the config file, the snippets in its drop-in directory (*.toml in lexical order)
+// and the extra config files (e.g. user config) in order, merging each into the previous ones.
+// Inaccessible files are skipped, a ConfAccessError is returned only if none of the files were accessible.
+// If the config file does not exist, the layers are merged into DefaultConf, as if it was the config file.
+func ReadConfLayers(path string, extraPaths ...string) (*Conf, error) {
+	return readConfLayers(path, extraPaths, nil)
+}
+
+// readConfLayers reads the config layers, adding the read files and their issues to the report if not nil
+func readConfLayers(path string, extraPaths []string, report *ConfReport) (*Conf, error) {
+	snippets, _ := filepath.Glob(filepath.Join(ConfDropInDir(path), "*.toml"))
+	conf := new(Conf)
+	var accessErr error
+	loaded := false
+	for _, layer := range append(append([]string{path}, snippets...), extraPaths...) {
+		if layer == "" {
+			continue
+		}
+		layerConf, err := readConfFile(layer, make(map[string]bool), report)
+		if _, ok := err.(ConfAccessError); ok {
+			if !errors.Is(err, os.ErrNotExist) {
+				report.warn("skipped %v", err)
+			} else if layer == path {
+				conf = DefaultConf() // no config file, no issue
+			}
+			if accessErr == nil {
+				accessErr = err
+			}
+			continue
+		}
+		if err != nil {
+			return conf, err
+		}
+		conf.merge(layerConf)
+		loaded = true
+	}
+	if !loaded {
+		return conf, accessErr
+	}
+	return conf, nil
 }
 
 // ConfFromEnvStr returns a Conf populated by strings as accepted environment variables
 // If the strings are empty, and empty Conf with no Criterion will be returned
-func ConfFromEnvStr(exitCodesStr, pattern string) (*Conf, error) {
+func ConfFromEnvStr(exitCodesStr, pattern, stderrPattern string) (*Conf, error) {
 	var err error
 	var exitCodes []int
-	var stdp StdoutPattern
+	var stdp, stderrp StdoutPattern
 	var reg *regexp.Regexp
 	criterion := new(Criterion)
 	conf := new(Conf)
@@ -256,6 +865,14 @@ func ConfFromEnvStr(exitCodesStr, pattern string) (*Conf, error) {
 		criterion.StdoutPatterns = []*StdoutPattern{&stdp}
 	}
 
+	if stderrPattern != "" {
+		if reg, err = regexp.Compile(stderrPattern); err != nil {
+			return conf, err
+		}
+		stderrp = StdoutPattern{Regexp: reg}
+		criterion.StderrPatterns = []*StdoutPattern{&stderrp}
+	}
+
 	if !criterion.IsEmpty() {
 		conf.Default.add(criterion)
 	}
@@ -263,29 +880,159 @@ func ConfFromEnvStr(exitCodesStr, pattern string) (*Conf, error) {
 	return conf, err
 }
 
-// GetCmdConf returns the Conf that the mute cmd will use based on env vars
-func GetCmdConf() (*Conf, error) {
-	var conf *Conf
+// CmdOptions are the mute cmd options, taking precedence over environment variables
+// Empty strings are ignored, ConfPath is used only when ConfPathSet is true
+// (an empty ConfPath means no config file lookup, same as the env var)
+type CmdOptions struct {
+	ExitCodes      string
+	StdoutPattern  string
+	StderrPattern  string
+	Timeout        string
+	KillGrace      string
+	MergeStreams   bool
+	NotifyRecovery bool
+	ProcessGroup   bool
+	StateDir       string
+	RecordDir      string
+	HistoryFile    string
+	MetricsDir     string
+	OutputFormat   string
+	ConfPath       string
+	ConfPathSet    bool
+}
+
+// optOrEnv returns the option value if not empty, otherwise value of the environment variable
+func optOrEnv(opt, env string) string {
+	if opt != "" {
+		return opt
+	}
+	return os.Getenv(env)
+}
+
+// ParseDuration parses a duration string like "1m30s", or a number of seconds
+func ParseDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Duration is a time.Duration in the config, a string like "1m30s", or a number of seconds
+type Duration time.Duration
+
+// UnmarshalTOML reads the duration, bare numbers are seconds like in options and environment variables
+func (d *Duration) UnmarshalTOML(v any) error {
+	switch value := v.(type) {
+	case int64:
+		*d = Duration(time.Duration(value) * time.Second)
+	case float64:
+		*d = Duration(value * float64(time.Second))
+	case string:
+		parsed, err := ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %v, should be a string like \"1m30s\" or a number of seconds", v)
+	}
+	return nil
+}
+
+// MarshalText writes the duration as a string like "1m30s"
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// String returns the duration formatted like time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// SettingsFromEnvStr returns Settings populated by strings as accepted environment variables
+// Empty strings leave the corresponding settings unset
+func SettingsFromEnvStr(timeoutStr, killGraceStr string) (*Settings, error) {
 	var err error
+	var duration time.Duration
+	settings := new(Settings)
+	if timeoutStr != "" {
+		if duration, err = ParseDuration(timeoutStr); err != nil {
+			return settings, err
+		}
+		settings.Timeout = Duration(duration)
+		settings.define("timeout")
+	}
+	if killGraceStr != "" {
+		if duration, err = ParseDuration(killGraceStr); err != nil {
+			return settings, err
+		}
+		settings.KillGrace = Duration(duration)
+		settings.define("kill_grace")
+	}
+	return settings, err
+}
+
+// GetCmdConf returns the Conf that the mute cmd will use based on options, env vars and config file
+// Options take precedence over env vars, which take precedence over the config file.
+// Criteria set by options or env vars replace the default, commands and rules criteria of the config files.
+// The config file is /etc/mute.toml and the snippets in /etc/mute.d, then the user config file
+// (see ReadConfLayers), unless a config file is set by options or env vars.
+// opts can be nil when there are no options.
+func GetCmdConf(opts *CmdOptions) (*Conf, error) {
+	return getCmdConf(opts, nil)
+}
 
-	envExitCodes := os.Getenv(EnvExitCodes)
-	envPattern := os.Getenv(EnvStdoutPattern)
-	conf, err = ConfFromEnvStr(envExitCodes, envPattern)
+// getCmdConf returns the Conf the same as GetCmdConf, adding the read files and their issues to the report if not nil
+func getCmdConf(opts *CmdOptions, report *ConfReport) (*Conf, error) {
+	var conf *Conf
+	var err error
 
-	if err != nil || !conf.IsEmpty() {
-		return conf, err
+	if opts == nil {
+		opts = new(CmdOptions)
+	}
+	exitCodes := optOrEnv(opts.ExitCodes, EnvExitCodes)
+	pattern := optOrEnv(opts.StdoutPattern, EnvStdoutPattern)
+	stderrPattern := optOrEnv(opts.StderrPattern, EnvStderrPattern)
+	settings, err := SettingsFromEnvStr(optOrEnv(opts.Timeout, EnvTimeout), optOrEnv(opts.KillGrace, EnvKillGrace))
+	if err != nil {
+		return new(Conf), err
+	}
+	settings.MergeStreams = opts.MergeStreams
+	settings.NotifyRecovery = opts.NotifyRecovery
+	settings.ProcessGroup = opts.ProcessGroup
+	settings.StateDir = optOrEnv(opts.StateDir, EnvStateDir)
+	settings.RecordDir = optOrEnv(opts.RecordDir, EnvRecordDir)
+	settings.HistoryFile = optOrEnv(opts.HistoryFile, EnvHistoryFile)
+	settings.MetricsDir = optOrEnv(opts.MetricsDir, EnvMetricsDir)
+	settings.OutputFormat = optOrEnv(opts.OutputFormat, EnvOutputFormat)
+	if err = settings.validate(); err != nil {
+		return new(Conf), err
+	}
+	criteria, err := ConfFromEnvStr(exitCodes, pattern, stderrPattern)
+	if err != nil {
+		return criteria, err
 	}
 
-	confPath := "/etc/mute.toml"
+	confPath, extraPaths := systemConfPath, []string{UserConfPath()}
 	envConfPath, envConfSet := os.LookupEnv(EnvConfig)
+	if opts.ConfPathSet {
+		envConfPath, envConfSet = opts.ConfPath, true
+	}
 	if envConfSet {
-		confPath = envConfPath
-		if confPath == "" {
-			conf = DefaultConf()
-			return conf, err
+		confPath, extraPaths = envConfPath, nil
+	}
+	if confPath == "" {
+		conf = DefaultConf()
+	} else {
+		conf, err = readConfLayers(confPath, extraPaths, report)
+	}
+	if !criteria.IsEmpty() {
+		report.warn("criteria are set by options or environment variables, default, commands and rules criteria of config files are not used")
+		conf.Default, conf.Commands, conf.Rules = criteria.Default, nil, nil
+		if _, ok := err.(ConfAccessError); ok {
+			err = nil // the criteria do not depend on the files
 		}
 	}
-
-	conf, err = ReadConfFile(confPath)
+	conf.override(settings)
 	return conf, err
 }
@@ -4,7 +4,11 @@ package mute
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestCodesContain(t *testing.T) {
@@ -36,7 +40,11 @@ func TestCriterionEmpty(t *testing.T) {
 	c1 := NewCriterion([]int{0}, []string{})
 	c2 := NewCriterion([]int{}, []string{""})
 	c3 := NewCriterion([]int{}, []string{})
+	c4 := NewCriterion([]int{}, []string{}).AddStderrPatterns("")
 
+	if c4.IsEmpty() {
+		t.Errorf("Criterion with stderr patterns IsEmpty got 'true' want 'false'")
+	}
 	if c1.IsEmpty() {
 		t.Errorf("Criterion with exit codes IsEmpty got 'true' want 'false'")
 	}
@@ -65,6 +73,11 @@ func TestCriterionEqual(t *testing.T) {
 	if c1.equal(c2) {
 		t.Errorf("Criterion.equal unmatched patterns got 'true' want 'false'")
 	}
+
+	c2 = NewCriterion([]int{0, 1, 2}, []string{}).AddStderrPatterns("ok")
+	if c1.equal(c2) {
+		t.Errorf("Criterion.equal unmatched stderr patterns got 'true' want 'false'")
+	}
 }
 
 func TestCriterionString(t *testing.T) {
@@ -199,12 +212,92 @@ func TestReadConfFileSimple(t *testing.T) {
 	}
 }
 
+func TestReadConfFileExclude(t *testing.T) {
+	c1 := NewCriterion([]int{0}, []string{}).AddStdoutExcludePatterns("WARN", "deprecated").AddStderrExcludePatterns("ERROR")
+	c2 := NewCriterion([]int{}, []string{"CRITICAL"})
+	want := new(Conf)
+	want.Default.add(c1)
+	want.NeverMute.add(c2)
+
+	got, err := ReadConfFile("test/data/exclude.toml")
+	if err != nil {
+		t.Errorf("ReadConfFile had error: %v", err)
+	}
+	if !want.equal(got) {
+		t.Errorf("ReadConfFile exclude didn't match want %v got %v", want, got)
+	}
+
+	noNeverMute := new(Conf)
+	noNeverMute.Default.add(c1)
+	if noNeverMute.equal(got) {
+		t.Errorf("ReadConfFile exclude matched conf without never_mute")
+	}
+}
+
+func TestReadConfFileSettings(t *testing.T) {
+	want := DefaultConf()
+	want.Settings = Settings{Timeout: Duration(time.Minute), KillGrace: Duration(10 * time.Second)}
+	want.CommandSettings = map[string]Settings{"backup": {Timeout: Duration(2 * time.Hour)}}
+
+	got, err := ReadConfFile("test/data/settings.toml")
+	if err != nil {
+		t.Errorf("ReadConfFile had error: %v", err)
+	}
+	if !want.equal(got) {
+		t.Errorf("ReadConfFile settings didn't match want %v got %v", want, got)
+	}
+
+	got.override(&Settings{Timeout: Duration(time.Second)})
+	if got.Settings.Timeout != Duration(time.Second) || got.Settings.KillGrace != Duration(10*time.Second) {
+		t.Errorf("Conf.override settings want timeout 1s kill grace 10s, got %v", got.Settings)
+	}
+	if got.CommandSettings["backup"].Timeout != 0 {
+		t.Errorf("Conf.override should reset command timeout, got %v", got.CommandSettings["backup"])
+	}
+}
+
+func TestSettingsFromEnvStr(t *testing.T) {
+	got, err := SettingsFromEnvStr("", "")
+	if err != nil {
+		t.Errorf("SettingsFromEnvStr empty want no error, got: %v", err)
+	}
+	if !got.equal(&Settings{}) {
+		t.Errorf("SettingsFromEnvStr empty want empty settings, got: %v", got)
+	}
+
+	got, err = SettingsFromEnvStr("30", "1m30s")
+	if err != nil {
+		t.Errorf("SettingsFromEnvStr want no error, got: %v", err)
+	}
+	if got.Timeout != Duration(30*time.Second) || got.KillGrace != Duration(90*time.Second) {
+		t.Errorf("SettingsFromEnvStr want timeout 30s kill grace 1m30s, got: %v", got)
+	}
+
+	if _, err = SettingsFromEnvStr("soon", ""); err == nil {
+		t.Errorf("SettingsFromEnvStr invalid timeout want error, got nil")
+	}
+}
+
+func TestReadConfFileSignals(t *testing.T) {
+	want := new(Conf)
+	want.Default.add(new(Criterion).AddSignals(syscall.SIGTERM, syscall.SIGPIPE))
+	want.NeverMute.add(new(Criterion).AddSignals(syscall.SIGSEGV))
+
+	got, err := ReadConfFile("test/data/signals.toml")
+	if err != nil {
+		t.Errorf("ReadConfFile had error: %v", err)
+	}
+	if !want.equal(got) {
+		t.Errorf("ReadConfFile signals didn't match want %v got %v", want, got)
+	}
+}
+
 func TestConfFromEnvStr(t *testing.T) {
 	var got, want, defaultConf *Conf
 	var err error
 	defaultConf = DefaultConf()
 
-	got, err = ConfFromEnvStr("", "")
+	got, err = ConfFromEnvStr("", "", "")
 	if err != nil {
 		t.Errorf("ConfFromEnvStr empty want no error, got: %v", err)
 	}
@@ -212,7 +305,7 @@ func TestConfFromEnvStr(t *testing.T) {
 		t.Errorf("ConfFromEnvStr want empty conf, got: %v", got)
 	}
 
-	got, err = ConfFromEnvStr("0", "")
+	got, err = ConfFromEnvStr("0", "", "")
 	if err != nil {
 		t.Errorf("ConfFromEnvStr default want no error, got: %v", err)
 	}
@@ -223,13 +316,29 @@ func TestConfFromEnvStr(t *testing.T) {
 	want = new(Conf)
 	c1 := NewCriterion([]int{1, 2}, []string{"[0-9]test"})
 	want.Default.add(c1)
-	got, err = ConfFromEnvStr("1,2", "[0-9]test")
+	got, err = ConfFromEnvStr("1,2", "[0-9]test", "")
 	if err != nil {
 		t.Errorf("ConfFromEnvStr test want no error, got: %v", err)
 	}
 	if !want.equal(got) {
 		t.Errorf("ConfFromEnvStr test want: %v, got: %v", want, got)
 	}
+
+	want = new(Conf)
+	c2 := NewCriterion([]int{0}, []string{}).AddStderrPatterns("^INFO")
+	want.Default.add(c2)
+	got, err = ConfFromEnvStr("0", "", "^INFO")
+	if err != nil {
+		t.Errorf("ConfFromEnvStr stderr want no error, got: %v", err)
+	}
+	if !want.equal(got) {
+		t.Errorf("ConfFromEnvStr stderr want: %v, got: %v", want, got)
+	}
+
+	_, err = ConfFromEnvStr("", "", "[")
+	if err == nil {
+		t.Errorf("ConfFromEnvStr invalid stderr pattern want error, got nil")
+	}
 }
 
 func TestGetCmdConfFromEnv(t *testing.T) {
@@ -240,9 +349,10 @@ func TestGetCmdConfFromEnv(t *testing.T) {
 
 	os.Unsetenv(EnvExitCodes)
 	os.Unsetenv(EnvStdoutPattern)
+	os.Unsetenv(EnvStderrPattern)
 
 	want = createSimpleConf()
-	got, err = GetCmdConf()
+	got, err = GetCmdConf(nil)
 	if err != nil {
 		t.Errorf("GetCmdConf no env conf want no error, got: %v", err)
 	}
@@ -255,7 +365,7 @@ func TestGetCmdConfFromEnv(t *testing.T) {
 	os.Setenv(EnvStdoutPattern, "")
 	defer os.Unsetenv(EnvStdoutPattern)
 
-	got, err = GetCmdConf()
+	got, err = GetCmdConf(nil)
 	if err != nil {
 		t.Errorf("GetCmdConf empty env conf want no error, got: %v", err)
 	}
@@ -269,7 +379,7 @@ func TestGetCmdConfFromEnv(t *testing.T) {
 
 	os.Setenv(EnvExitCodes, "4,5")
 	os.Setenv(EnvStdoutPattern, "[0-9]test")
-	got, err = GetCmdConf()
+	got, err = GetCmdConf(nil)
 
 	if err != nil {
 		t.Errorf("GetCmdConf env conf want no error, got: %v", err)
@@ -279,7 +389,7 @@ func TestGetCmdConfFromEnv(t *testing.T) {
 	}
 
 	os.Setenv(EnvExitCodes, "4,z")
-	got, err = GetCmdConf()
+	got, err = GetCmdConf(nil)
 
 	if err == nil {
 		t.Errorf("GetCmdConf env inavlid exit code want error, got: %v", got)
@@ -290,7 +400,7 @@ func TestGetCmdConfFromEnv(t *testing.T) {
 
 	os.Setenv(EnvExitCodes, "")
 	os.Setenv(EnvStdoutPattern, "[")
-	got, err = GetCmdConf()
+	got, err = GetCmdConf(nil)
 
 	if err == nil {
 		t.Errorf("GetCmdConf env inavlid stdout pattern want error, got: %v", got)
@@ -305,7 +415,7 @@ func TestGetCmdConfFromFile(t *testing.T) {
 	defer os.Unsetenv(EnvConfig)
 	want := createSimpleConf()
 	defaultConf := DefaultConf()
-	got, err := GetCmdConf()
+	got, err := GetCmdConf(nil)
 	if err != nil {
 		t.Errorf("GetCmdConf simple want no error, got: %v", err)
 	}
@@ -314,7 +424,7 @@ func TestGetCmdConfFromFile(t *testing.T) {
 	}
 
 	os.Setenv(EnvConfig, "")
-	got, err = GetCmdConf()
+	got, err = GetCmdConf(nil)
 	if err != nil {
 		t.Errorf("GetCmdConf empty env want no error, got: %v", err)
 	}
@@ -323,6 +433,77 @@ func TestGetCmdConfFromFile(t *testing.T) {
 	}
 }
 
+func TestGetCmdConfFromOptions(t *testing.T) {
+	var got, want *Conf
+	var err error
+	os.Setenv(EnvConfig, "test/data/no_such_file.toml")
+	defer os.Unsetenv(EnvConfig)
+	os.Setenv(EnvExitCodes, "4,5")
+	defer os.Unsetenv(EnvExitCodes)
+	os.Setenv(EnvStdoutPattern, "[0-9]test")
+	defer os.Unsetenv(EnvStdoutPattern)
+	os.Unsetenv(EnvStderrPattern)
+
+	opts := &CmdOptions{ExitCodes: "3"}
+	want = new(Conf)
+	want.Default.add(NewCriterion([]int{3}, []string{"[0-9]test"}))
+	got, err = GetCmdConf(opts)
+	if err != nil {
+		t.Errorf("GetCmdConf options want no error, got: %v", err)
+	}
+	if !want.equal(got) {
+		t.Errorf("GetCmdConf options want %v got %v", want, got)
+	}
+
+	os.Setenv(EnvExitCodes, "")
+	os.Setenv(EnvStdoutPattern, "")
+	opts = &CmdOptions{ConfPath: "test/data/simple.toml", ConfPathSet: true}
+	want = createSimpleConf()
+	got, err = GetCmdConf(opts)
+	if err != nil {
+		t.Errorf("GetCmdConf options conf path want no error, got: %v", err)
+	}
+	if !want.equal(got) {
+		t.Errorf("GetCmdConf options conf path want simple %v got %v", want, got)
+	}
+
+	opts = &CmdOptions{ConfPath: "test/data/settings.toml", ConfPathSet: true, Timeout: "5s"}
+	os.Setenv(EnvTimeout, "10s")
+	defer os.Unsetenv(EnvTimeout)
+	os.Setenv(EnvKillGrace, "3s")
+	defer os.Unsetenv(EnvKillGrace)
+	got, err = GetCmdConf(opts)
+	if err != nil {
+		t.Errorf("GetCmdConf options timeout want no error, got: %v", err)
+	}
+	if got.Settings.Timeout != Duration(5*time.Second) || got.Settings.KillGrace != Duration(3*time.Second) {
+		t.Errorf("GetCmdConf options timeout want 5s kill grace 3s, got %v", got.Settings)
+	}
+	os.Unsetenv(EnvTimeout)
+	os.Unsetenv(EnvKillGrace)
+
+	opts = &CmdOptions{ConfPath: "test/data/settings.toml", ConfPathSet: true, ExitCodes: "0,3"}
+	got, err = GetCmdConf(opts)
+	if err != nil {
+		t.Errorf("GetCmdConf options criteria and conf path want no error, got: %v", err)
+	}
+	want = new(Conf)
+	want.Default.add(NewCriterion([]int{0, 3}, []string{}))
+	if !want.Default.equal(&got.Default) || got.Settings.Timeout != Duration(time.Minute) ||
+		got.CommandSettings["backup"].Timeout != Duration(2*time.Hour) {
+		t.Errorf("GetCmdConf options criteria want criteria of options with settings of conf path, got %v", got)
+	}
+
+	opts = &CmdOptions{ConfPath: "", ConfPathSet: true}
+	got, err = GetCmdConf(opts)
+	if err != nil {
+		t.Errorf("GetCmdConf options empty conf path want no error, got: %v", err)
+	}
+	if !DefaultConf().equal(got) {
+		t.Errorf("GetCmdConf options empty conf path want default conf, got %v", got)
+	}
+}
+
 // createSimpleConf returns a Conf with simple criterions for testing
 func createSimpleConf() *Conf {
 	c1 := NewCriterion([]int{0}, []string{})
@@ -332,3 +513,272 @@ func createSimpleConf() *Conf {
 	conf.Commands = make(map[string]Criteria)
 	return conf
 }
+
+func TestReadConfFileUnchanged(t *testing.T) {
+	got, err := ReadConfFile("test/data/unchanged.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile unchanged had error: %v", err)
+	}
+	crt := got.Default[1]
+	if !crt.MuteIfUnchanged || crt.RealertAfter != Duration(24*time.Hour) || crt.IsEmpty() {
+		t.Errorf("ReadConfFile unchanged want mute if unchanged, realert 24h got: %v", crt)
+	}
+	subs := got.Settings.OutputSubstitutions
+	if len(subs) != 1 || subs[0].Pattern.String() != `\d{2}:\d{2}:\d{2}` || subs[0].Replace != "TIME" {
+		t.Errorf("ReadConfFile unchanged output substitutions got: %v", subs)
+	}
+}
+
+func TestReadConfFileNotify(t *testing.T) {
+	got, err := ReadConfFile("test/data/notify.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile notify had error: %v", err)
+	}
+	webhooks := got.Notify.Webhooks
+	if len(webhooks) != 1 || webhooks[0].URL != "https://chat.example.com/hooks/cron" || webhooks[0].Timeout != Duration(5*time.Second) ||
+		webhooks[0].Retries != 3 || webhooks[0].Headers["Authorization"] != "Bearer token" {
+		t.Errorf("ReadConfFile notify want the webhook, got: %+v", webhooks)
+	}
+	emails := got.Notify.Emails
+	if len(emails) != 1 || emails[0].To[0] != "ops@example.com" || emails[0].SMTPServer != "smtp.example.com:587" ||
+		emails[0].MaxInlineBytes != 1024 {
+		t.Errorf("ReadConfFile notify want the email, got: %+v", emails)
+	}
+	if len(got.Notify.Syslogs) != 1 || got.Notify.Syslogs[0].Facility != "cron" || !got.Notify.Syslogs[0].Muted {
+		t.Errorf("ReadConfFile notify want the syslog, got: %+v", got.Notify.Syslogs)
+	}
+	if len(got.Notify.Journals) != 1 || got.Notify.Journals[0].Identifier != "cron-jobs" {
+		t.Errorf("ReadConfFile notify want the journal, got: %+v", got.Notify.Journals)
+	}
+	if len(got.Notify.Notifiers()) != 4 {
+		t.Errorf("ReadConfFile notify want 4 notifiers, got: %v", got.Notify.Notifiers())
+	}
+	merged := DefaultConf().merge(got).merge(got)
+	if len(merged.Notify.Webhooks) != 2 || len(merged.Notify.Emails) != 2 || merged.equal(got) {
+		t.Errorf("Conf.merge want notifiers appended, got: %+v", merged.Notify)
+	}
+	if _, err = ReadConfFile("test/data/notify-invalid.toml"); err == nil || !strings.Contains(err.Error(), "notify.webhook[0]") {
+		t.Errorf("ReadConfFile invalid webhook want error, got: %v", err)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	if err := (&Rule{}).validate(); err == nil {
+		t.Errorf("Rule.validate without selectors want error, got nil")
+	}
+	if err := (&Rule{CommandGlob: "[backup"}).validate(); err == nil {
+		t.Errorf("Rule.validate invalid glob want error, got nil")
+	}
+	if err := (&Rule{Basename: "backup.sh", ArgsRegex: NewStdoutPattern("--full")}).validate(); err != nil {
+		t.Errorf("Rule.validate want no error, got: %v", err)
+	}
+}
+
+func TestConfEqualInheritDefault(t *testing.T) {
+	conf, err := ReadConfFile("test/data/inherit.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile inherit had error: %v", err)
+	}
+	want := &Conf{Default: Criteria{NewCriterion([]int{0}, nil)}, Commands: map[string]Criteria{
+		"backup": {&Criterion{ExitCodes: []int{3}, InheritDefault: true}},
+		"rsync":  {NewCriterion([]int{24}, nil)},
+	}}
+	if !want.equal(conf) {
+		t.Errorf("ReadConfFile inherit want %v got %v", want, conf)
+	}
+	want.Commands["backup"][0].InheritDefault = false
+	if want.equal(conf) {
+		t.Errorf("Conf.equal should compare inherit_default, got equal")
+	}
+}
+
+func TestReadConfFileInclude(t *testing.T) {
+	got, err := ReadConfFile("test/data/layers.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile include had error: %v", err)
+	}
+	want := new(Conf)
+	want.NeverMute.add(NewCriterion(nil, []string{"CRITICAL"}))
+	if !got.NeverMute.equal(&want.NeverMute) {
+		t.Errorf("ReadConfFile include never mute want %v got %v", want.NeverMute, got.NeverMute)
+	}
+
+	for _, path := range []string{"test/data/include-cycle.toml", "test/data/include-missing.toml", "test/data/invalid.toml"} {
+		_, err = ReadConfFile(path)
+		if _, ok := err.(ConfFileError); !ok {
+			t.Errorf("ReadConfFile %v want ConfFileError got: %v", path, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), path) {
+			t.Errorf("ReadConfFile %v error should name the file, got: %v", path, err)
+		}
+	}
+}
+
+func TestReadConfLayers(t *testing.T) {
+	got, err := ReadConfLayers("test/data/layers.toml", "test/data/no_such_file.toml", "test/data/layers-user.toml")
+	if err != nil {
+		t.Fatalf("ReadConfLayers had error: %v", err)
+	}
+	want := Criteria{NewCriterion([]int{0}, nil), NewCriterion([]int{24}, nil)}
+	if !got.Default.equal(&want) {
+		t.Errorf("ReadConfLayers default criteria want appended %v got %v", want, got.Default)
+	}
+	want = Criteria{NewCriterion([]int{2}, nil)}
+	backup := got.Commands["backup"]
+	if !backup.equal(&want) {
+		t.Errorf("ReadConfLayers command criteria want replaced %v got %v", want, backup)
+	}
+	if got.Settings.Timeout != Duration(4*time.Minute) || got.Settings.KillGrace != Duration(3*time.Second) {
+		t.Errorf("ReadConfLayers settings want timeout 4m kill grace 3s got %v", got.Settings)
+	}
+	if len(got.NeverMute) != 1 {
+		t.Errorf("ReadConfLayers want included never mute criteria got %v", got.NeverMute)
+	}
+
+	_, err = ReadConfLayers("test/data/no_such_file.toml", "test/data/no_such_user_file.toml")
+	if _, ok := err.(ConfAccessError); !ok {
+		t.Errorf("ReadConfLayers no files want ConfAccessError got: %v", err)
+	}
+	_, err = ReadConfLayers("test/data/no_such_file.toml", "test/data/invalid.toml")
+	if _, ok := err.(ConfFileError); !ok {
+		t.Errorf("ReadConfLayers invalid file want ConfFileError got: %v", err)
+	}
+}
+
+func TestReadConfLayersSnippetOnly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "mute.d"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	snippet := "[[ commands.backup ]]\nexit_codes = [0, 3]\n"
+	if err := os.WriteFile(filepath.Join(dir, "mute.d", "10-backup.toml"), []byte(snippet), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadConfLayers(filepath.Join(dir, "mute.toml"))
+	if err != nil {
+		t.Fatalf("ReadConfLayers snippet only had error: %v", err)
+	}
+	if want := DefaultConf().Default; !got.Default.equal(&want) {
+		t.Errorf("ReadConfLayers snippet only want default criteria %v got %v", want, got.Default)
+	}
+	if len(got.Commands["backup"]) != 1 {
+		t.Errorf("ReadConfLayers snippet only want snippet criteria got %v", got.Commands)
+	}
+}
+
+func TestGetCmdConfUserConf(t *testing.T) {
+	dir := t.TempDir()
+	defer func(path string) { systemConfPath = path }(systemConfPath)
+	systemConfPath = filepath.Join(dir, "mute.toml") // not the config of the host
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+	os.Unsetenv(EnvConfig)
+	if UserConfPath() != dir+"/mute/config.toml" {
+		t.Errorf("UserConfPath want in XDG_CONFIG_HOME got: %v", UserConfPath())
+	}
+	if err := os.MkdirAll(dir+"/mute", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(UserConfPath(), []byte("[[ default ]]\nexit_codes = [7]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetCmdConf(nil)
+	if err != nil {
+		t.Errorf("GetCmdConf user conf want no error, got: %v", err)
+	}
+	if len(got.Default) != 2 || !codesContain(got.Default[1].ExitCodes, 7) {
+		t.Errorf("GetCmdConf want default and user conf criteria, got: %v", got.Default)
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	_, err := ReadConfFile("test/data/format-invalid.toml")
+	if err == nil || !strings.Contains(err.Error(), "command_settings.backup") {
+		t.Errorf("ReadConfFile invalid output format want error, got: %v", err)
+	}
+	os.Setenv(EnvOutputFormat, "json")
+	defer os.Unsetenv(EnvOutputFormat)
+	opts := &CmdOptions{ConfPath: "test/data/simple.toml", ConfPathSet: true}
+	got, err := GetCmdConf(opts)
+	if err != nil || got.Settings.OutputFormat != OutputFormatJSON {
+		t.Errorf("GetCmdConf output format env want json, got: %v %v", got.Settings.OutputFormat, err)
+	}
+	opts.OutputFormat = "yaml"
+	if _, err = GetCmdConf(opts); err == nil {
+		t.Errorf("GetCmdConf invalid output format option want error, got nil")
+	}
+
+	conf := DefaultConf()
+	conf.CommandSettings = map[string]Settings{"backup": {OutputFormat: OutputFormatJSON}}
+	conf.override(&Settings{OutputFormat: OutputFormatRaw})
+	if conf.Settings.OutputFormat != OutputFormatRaw || conf.CommandSettings["backup"].OutputFormat != "" {
+		t.Errorf("Conf.override should reset command output format, got %v", conf.CommandSettings["backup"])
+	}
+}
+
+func TestReadConfFileDurationSeconds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mute.toml")
+	content := "[ settings ]\ntimeout = 300\nkill_grace = 10\n\n[[ default ]]\nmute_if_unchanged = true\nrealert_after = 1.5\n\n" +
+		"[[ notify.webhook ]]\nurl = \"https://example.com/hook\"\ntimeout = 3\nretry_backoff = \"500ms\"\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadConfFile(path)
+	if err != nil {
+		t.Fatalf("ReadConfFile durations in seconds had error: %v", err)
+	}
+	if got.Settings.Timeout != Duration(5*time.Minute) || got.Settings.KillGrace != Duration(10*time.Second) {
+		t.Errorf("ReadConfFile want timeout 5m kill grace 10s, got: %v %v", got.Settings.Timeout, got.Settings.KillGrace)
+	}
+	if got.Default[0].RealertAfter != Duration(1500*time.Millisecond) {
+		t.Errorf("ReadConfFile want realert after 1.5s, got: %v", got.Default[0].RealertAfter)
+	}
+	webhook := got.Notify.Webhooks[0]
+	if webhook.Timeout != Duration(3*time.Second) || webhook.RetryBackoff != Duration(500*time.Millisecond) {
+		t.Errorf("ReadConfFile want webhook timeout 3s backoff 500ms, got: %v %v", webhook.Timeout, webhook.RetryBackoff)
+	}
+
+	if err = os.WriteFile(path, []byte("[ settings ]\ntimeout = true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadConfFile(path); err == nil {
+		t.Errorf("ReadConfFile invalid duration want error, got nil")
+	}
+}
+
+func TestReadConfLayersExplicitZero(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "mute.d"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	system := "[ settings ]\ntimeout = \"1m\"\nprocess_group = true\nnotify_recovery = true\n"
+	if err := os.WriteFile(filepath.Join(dir, "mute.toml"), []byte(system), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	snippet := "[ settings ]\ntimeout = 0\nprocess_group = false\n\n[ command_settings.backup ]\nnotify_recovery = false\n"
+	if err := os.WriteFile(filepath.Join(dir, "mute.d", "10-local.toml"), []byte(snippet), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadConfLayers(filepath.Join(dir, "mute.toml"))
+	if err != nil {
+		t.Fatalf("ReadConfLayers explicit zero had error: %v", err)
+	}
+	if got.Settings.Timeout != 0 || got.Settings.ProcessGroup || !got.Settings.NotifyRecovery {
+		t.Errorf("ReadConfLayers want explicit zero settings to override, got: %v", got.Settings)
+	}
+	if settings := cmdSettings("backup", got); settings.NotifyRecovery {
+		t.Errorf("cmdSettings want explicit false command settings to override, got: %v", settings)
+	}
+	if settings := cmdSettings("cleanup", got); !settings.NotifyRecovery || !got.Settings.NotifyRecovery {
+		t.Errorf("cmdSettings want global settings for other commands unchanged, got: %v", settings)
+	}
+
+	opts := &CmdOptions{ConfPath: "test/data/settings.toml", ConfPathSet: true, Timeout: "0"}
+	conf, err := GetCmdConf(opts)
+	if err != nil {
+		t.Fatalf("GetCmdConf options zero timeout had error: %v", err)
+	}
+	if conf.Settings.Timeout != 0 || cmdSettings("backup", conf).Timeout != 0 || conf.Settings.KillGrace != Duration(10*time.Second) {
+		t.Errorf("GetCmdConf options zero timeout want no timeout, got: %v %v", conf.Settings, conf.CommandSettings)
+	}
+}
@@ -0,0 +1,282 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultSendmailPath is the default path of the sendmail compatible binary to deliver emails
+const DefaultSendmailPath = "/usr/sbin/sendmail"
+
+// DefaultEmailTimeout is the default timeout of delivering an email
+const DefaultEmailTimeout = 30 * time.Second
+
+// DefaultEmailMaxInlineBytes is the default size of stdout/stderr to include in the email body, larger ones are attached
+const DefaultEmailMaxInlineBytes = 64 * 1024
+
+// Email is a Notifier delivering emails, through the SMTP server if set or the sendmail binary
+type Email struct {
+	To   []string `toml:"to"`
+	From string   `toml:"from,omitempty"` // mute@HOST by default
+	// sendmail compatible binary to deliver emails when SMTPServer is not set, see DefaultSendmailPath
+	Sendmail string `toml:"sendmail,omitempty"`
+	// SMTP server address as host:port, STARTTLS is used if the server supports it
+	SMTPServer   string `toml:"smtp_server,omitempty"`
+	SMTPUsername string `toml:"smtp_username,omitempty"`
+	SMTPPassword string `toml:"smtp_password,omitempty"`
+	// timeout of delivering each email, see DefaultEmailTimeout
+	Timeout Duration `toml:"timeout,omitzero"`
+	// stdout/stderr larger than this are attached as files, see DefaultEmailMaxInlineBytes
+	MaxInlineBytes int `toml:"max_inline_bytes,omitzero"`
+}
+
+// Email.validate checks the addresses and the SMTP server
+func (e *Email) validate() error {
+	if len(e.To) == 0 {
+		return errors.New("no recipients in to")
+	}
+	for _, addr := range append([]string{e.From}, e.To...) {
+		if _, err := mail.ParseAddress(addr); addr != "" && err != nil {
+			return fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+	}
+	if e.SMTPServer != "" {
+		if _, _, err := net.SplitHostPort(e.SMTPServer); err != nil {
+			return fmt.Errorf("invalid smtp_server: %w", err)
+		}
+	}
+	return nil
+}
+
+// Email.equal checks if the Emails have the same configuration
+func (e *Email) equal(e2 *Email) bool {
+	if len(e.To) != len(e2.To) {
+		return false
+	}
+	for i := range e.To {
+		if e.To[i] != e2.To[i] {
+			return false
+		}
+	}
+	return e.From == e2.From && e.Sendmail == e2.Sendmail && e.SMTPServer == e2.SMTPServer &&
+		e.SMTPUsername == e2.SMTPUsername && e.SMTPPassword == e2.SMTPPassword && e.Timeout == e2.Timeout &&
+		e.MaxInlineBytes == e2.MaxInlineBytes
+}
+
+// Email.from returns the sender address
+func (e *Email) from(n *Notification) string {
+	if e.From != "" {
+		return e.From
+	}
+	return "mute@" + n.Host
+}
+
+// Email.envelope returns the sender and the recipients addresses without the display names, as SMTP expects
+func (e *Email) envelope(n *Notification) (string, []string, error) {
+	from, err := mail.ParseAddress(e.from(n))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid address %q: %w", e.from(n), err)
+	}
+	to := make([]string, 0, len(e.To))
+	for _, addr := range e.To {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+		to = append(to, parsed.Address)
+	}
+	return from.Address, to, nil
+}
+
+// Notify delivers the Notification as an email to the recipients
+func (e *Email) Notify(ctx context.Context, n *Notification) error {
+	timeout := time.Duration(e.Timeout)
+	if timeout == 0 {
+		timeout = DefaultEmailTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var from string
+	var to []string
+	msg, err := e.message(n, time.Now())
+	if err == nil {
+		from, to, err = e.envelope(n)
+	}
+	if err == nil {
+		if e.SMTPServer != "" {
+			err = e.sendSMTP(ctx, from, to, msg)
+		} else {
+			err = e.sendmail(ctx, from, to, msg)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("email to %v: %w", strings.Join(e.To, ", "), err)
+	}
+	return nil
+}
+
+// Email.message returns the MIME message of the Notification,
+// with the stdout/stderr in the body, or attached if larger than MaxInlineBytes
+func (e *Email) message(n *Notification, date time.Time) ([]byte, error) {
+	maxInline := e.MaxInlineBytes
+	if maxInline == 0 {
+		maxInline = DefaultEmailMaxInlineBytes
+	}
+	var buf bytes.Buffer
+	parts := multipart.NewWriter(&buf)
+	subject := fmt.Sprintf("mute: %v %v on %v", StateKey(n.Cmd, n.Args), n.status(), n.Host)
+	fmt.Fprintf(&buf, "From: %v\r\nTo: %v\r\nSubject: %v\r\nDate: %v\r\nMIME-Version: 1.0\r\n"+
+		"Content-Type: multipart/mixed; boundary=%q\r\n\r\n",
+		e.from(n), strings.Join(e.To, ", "), mime.QEncoding.Encode("utf-8", subject), date.Format(time.RFC1123Z),
+		parts.Boundary())
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Command: %v\nHost: %v\nStatus: %v\nStarted: %v\nDuration: %v\n",
+		StateKey(n.Cmd, n.Args), n.Host, n.status(), n.StartTime.Format(time.RFC3339),
+		time.Duration(n.DurationSeconds*float64(time.Second)))
+	if n.NeverMuteCriterion != "" {
+		fmt.Fprintf(&body, "Never mute criterion: %v\n", n.NeverMuteCriterion)
+	}
+	var attachments []string
+	for _, stream := range []struct{ name, output string }{{"stdout", n.Stdout}, {"stderr", n.Stderr}} {
+		switch {
+		case stream.output == "":
+			fmt.Fprintf(&body, "\n%v: empty\n", stream.name)
+		case maxInline >= 0 && len(stream.output) > maxInline:
+			fmt.Fprintf(&body, "\n%v: %v bytes, attached as %v.txt\n", stream.name, len(stream.output), stream.name)
+			attachments = append(attachments, stream.name)
+		default:
+			fmt.Fprintf(&body, "\n%v:\n%v\n", stream.name, strings.TrimSuffix(stream.output, "\n"))
+		}
+	}
+	part, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err = qp.Write([]byte(body.String())); err != nil {
+		return nil, err
+	}
+	if err = qp.Close(); err != nil {
+		return nil, err
+	}
+	for _, name := range attachments {
+		output := n.Stdout
+		if name == "stderr" {
+			output = n.Stderr
+		}
+		part, err = parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf("text/plain; charset=utf-8; name=\"%v.txt\"", name)},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=\"%v.txt\"", name)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeBase64Lines(part, []byte(output)); err != nil {
+			return nil, err
+		}
+	}
+	err = parts.Close()
+	return buf.Bytes(), err
+}
+
+// writeBase64Lines writes the data base64 encoded in lines of 76 characters, as required by MIME
+func writeBase64Lines(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(76, len(encoded))
+		if _, err := fmt.Fprintf(w, "%v\r\n", encoded[:n]); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// Email.sendmail delivers the message by the sendmail binary, to the envelope addresses
+func (e *Email) sendmail(ctx context.Context, from string, to []string, msg []byte) error {
+	path := e.Sendmail
+	if path == "" {
+		path = DefaultSendmailPath
+	}
+	args := append([]string{"-i", "-f", from, "--"}, to...)
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = bytes.NewReader(msg)
+	output, err := cmd.CombinedOutput()
+	if err != nil && len(output) > 0 {
+		return fmt.Errorf("%v: %w: %v", path, err, strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	return nil
+}
+
+// Email.sendSMTP delivers the message to the SMTP server, to the envelope addresses
+func (e *Email) sendSMTP(ctx context.Context, from string, to []string, msg []byte) error {
+	host, _, _ := net.SplitHostPort(e.SMTPServer)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", e.SMTPServer)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.SMTPUsername != "" {
+		if err = client.Auth(smtp.PlainAuth("", e.SMTPUsername, e.SMTPPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err = client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,184 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readTestEmail parses the email message, and returns the decoded subject and the parts by file name ("" for body)
+func readTestEmail(t *testing.T, msg []byte) (string, map[string]string) {
+	t.Helper()
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("invalid email message: %v", err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("invalid email content type: %v", err)
+	}
+	parts := make(map[string]string)
+	reader := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid email part: %v", err)
+		}
+		content, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			content, _ = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(content), "\r\n", ""))
+		}
+		parts[part.FileName()] = strings.ReplaceAll(string(content), "\r\n", "\n")
+	}
+	return subject, parts
+}
+
+func TestEmailMessage(t *testing.T) {
+	n := testNotification()
+	email := Email{To: []string{"ops@example.com"}, MaxInlineBytes: 50}
+	msg, err := email.message(n, time.Now())
+	if err != nil {
+		t.Fatalf("Email.message had error: %v", err)
+	}
+	subject, parts := readTestEmail(t, msg)
+	if subject != "mute: backup --full exited with 3 on db1" {
+		t.Errorf("Email.message want subject with the command, exit code and host, got: %v", subject)
+	}
+	if body := parts[""]; !strings.Contains(body, "stdout:\ncopied\n") ||
+		!strings.Contains(body, "stderr: 107 bytes, attached as stderr.txt") {
+		t.Errorf("Email.message want stdout in the body and stderr attached, got: %v", body)
+	}
+	if parts["stderr.txt"] != n.Stderr {
+		t.Errorf("Email.message want stderr attachment, got: %q", parts["stderr.txt"])
+	}
+	if !bytes.Contains(msg, []byte("From: mute@db1\r\n")) {
+		t.Errorf("Email.message want default sender mute@HOST, got: %s", msg)
+	}
+}
+
+func TestEmailSendmail(t *testing.T) {
+	dir := t.TempDir()
+	sendmail := filepath.Join(dir, "sendmail")
+	script := "#!/bin/sh\necho \"$@\" > " + dir + "/args\ncat > " + dir + "/msg\n"
+	if err := os.WriteFile(sendmail, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	email := Email{To: []string{"ops@example.com", "Dev <dev@example.com>"}, From: "Cron <cron@db1>", Sendmail: sendmail}
+	if err := email.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Email.Notify sendmail had error: %v", err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if string(args) != "-i -f cron@db1 -- ops@example.com dev@example.com\n" {
+		t.Errorf("Email.Notify sendmail want sender and recipients args, got: %s", args)
+	}
+	msg, _ := os.ReadFile(filepath.Join(dir, "msg"))
+	if subject, _ := readTestEmail(t, msg); !strings.Contains(subject, "backup --full") {
+		t.Errorf("Email.Notify sendmail want the message, got: %s", msg)
+	}
+
+	email.Sendmail = filepath.Join(dir, "missing")
+	if err := email.Notify(context.Background(), testNotification()); err == nil {
+		t.Errorf("Email.Notify missing sendmail want error, got nil")
+	}
+}
+
+// serveTestSMTP serves one SMTP session on the listener, and sends the received envelope and data to the channel
+func serveTestSMTP(t *testing.T, listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	var envelope strings.Builder
+	reply("220 localhost ESMTP test")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+			envelope.WriteString(strings.TrimSpace(line) + "\n")
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end with .")
+			for {
+				data, err := reader.ReadString('\n')
+				if err != nil || data == ".\r\n" {
+					break
+				}
+				envelope.WriteString(strings.TrimPrefix(data, "."))
+			}
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			received <- envelope.String()
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestEmailSMTP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go serveTestSMTP(t, listener, received)
+	email := Email{To: []string{"Ops <ops@example.com>"}, From: "Cron <cron@db1>", SMTPServer: listener.Addr().String(),
+		Timeout: Duration(5 * time.Second)}
+	if err = email.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Email.Notify smtp had error: %v", err)
+	}
+	got := <-received
+	if !strings.Contains(got, "MAIL FROM:<cron@db1>") || !strings.Contains(got, "RCPT TO:<ops@example.com>") {
+		t.Errorf("Email.Notify smtp want the envelope, got: %v", got)
+	}
+	if !strings.Contains(got, "From: Cron <cron@db1>\r\nTo: Ops <ops@example.com>\r\n") {
+		t.Errorf("Email.Notify smtp want display names in the headers, got: %v", got)
+	}
+	if !strings.Contains(got, "Subject: mute: backup --full exited with 3 on db1") {
+		t.Errorf("Email.Notify smtp want the message, got: %v", got)
+	}
+}
+
+func TestEmailValidate(t *testing.T) {
+	for _, email := range []Email{
+		{},
+		{To: []string{"not an address"}},
+		{To: []string{"ops@example.com"}, From: "@"},
+		{To: []string{"ops@example.com"}, SMTPServer: "smtp.example.com"},
+	} {
+		if err := email.validate(); err == nil {
+			t.Errorf("Email.validate want error for %+v, got nil", email)
+		}
+	}
+	if err := (&Email{To: []string{"Ops <ops@example.com>"}, SMTPServer: "smtp.example.com:25"}).validate(); err != nil {
+		t.Errorf("Email.validate want no error, got: %v", err)
+	}
+}
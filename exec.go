@@ -3,7 +3,8 @@
 package mute
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -11,18 +12,74 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // ExitErrExec is exit code when failed to execute the command
 const ExitErrExec = 127
 
+// ExitErrTimeout is exit code when the command timed out
+const ExitErrTimeout = 124
+
 // execContext is the details of an executed command
 type execContext struct {
-	Cmd        string
-	ExitCode   int
-	StdoutText *string
-	StderrText *string
-	Error      error
+	Cmd       string
+	ExitCode  int
+	Signal    syscall.Signal // signal that terminated the command, zero if it exited
+	Stdout    *spool
+	Stderr    *spool
+	Chunks    *chunkLog // order of the output chunks, nil when not recorded
+	Error     error
+	TimedOut  bool
+	StartTime time.Time
+	Duration  time.Duration
+	// ConsecutiveFailures is the number of failed runs in a row including this one, from the state store
+	ConsecutiveFailures int
+	// OutputHash is the hash of the normalized output, if compared with the previous run
+	OutputHash string
+	// previous is the state of the previous runs, nil if not loaded
+	previous *RunState
+}
+
+// failed checks if the command failed (non zero exit code, terminated by a signal or timed out)
+func (ec *execContext) failed() bool {
+	return ec.ExitCode != 0 || ec.Signal != 0 || ec.TimedOut
+}
+
+// outputUnchanged checks if the output is the same as the previous run,
+// and was printed within the realert duration (zero means no realert)
+func (ec *execContext) outputUnchanged(realert time.Duration) bool {
+	if ec.previous == nil || ec.OutputHash == "" || ec.previous.OutputHash != ec.OutputHash {
+		return false
+	}
+	return realert <= 0 || ec.StartTime.Sub(ec.previous.LastAlert) < realert
+}
+
+// writeOutput writes stdout and stderr of the command to the writers
+// If the chunks were recorded, the output is written in the order it was received,
+// and merge writes both streams to outWriter.
+func (ec *execContext) writeOutput(outWriter, errWriter io.Writer, merge bool) {
+	if ec.Chunks != nil {
+		if merge {
+			errWriter = nil
+		}
+		_ = ec.Chunks.replay(ec.Stdout, ec.Stderr, outWriter, errWriter)
+		return
+	}
+	_, _ = ec.Stdout.WriteTo(outWriter)
+	_, _ = ec.Stderr.WriteTo(errWriter)
+}
+
+// outputTail returns the last maxBytes of stdout and stderr (all if not positive), to report the run
+// without reading the output spilled to the temp files into memory
+func (ec *execContext) outputTail(maxBytes int64) (string, string) {
+	return ec.Stdout.Tail(maxBytes), ec.Stderr.Tail(maxBytes)
+}
+
+// close releases resources used to store the output of the command
+func (ec *execContext) close() {
+	ec.Stdout.Close()
+	ec.Stderr.Close()
 }
 
 // Target is the struct to specify what to exec, when to mute and where to print otherwise
@@ -32,115 +89,480 @@ type Target struct {
 	Conf        *Conf
 	OutWriter   io.Writer
 	ErrWriter   io.Writer
-	BufPreAlloc int // initial size (bytes) of the buffer for stdout/stderr
+	BufPreAlloc int // initial size (bytes) of the buffer for stdout/stderr, capped by Settings.MaxBufferBytes
+	// CancelSignal is sent to the command when the context is done or timed out, SIGTERM by default.
+	// The command is killed if still running after the kill grace period.
+	CancelSignal syscall.Signal
+	// StateKey identifies the command in the state store, defaults to the command and arguments
+	StateKey string
+	// Notifiers are notified of unmuted runs (and muted runs, see MutedNotifier), in addition to the notifiers in Conf
+	Notifiers []Notifier
+	// Job names the command in metrics, defaults to the command basename (see JobName)
+	Job string
+	// DisableSignalForwarding prevents handling the catchable signals of the current process
+	// (SIGINT, SIGTERM, SIGHUP, etc.) to forward them to the command,
+	// useful when the process handles the signals itself (e.g. a daemon)
+	DisableSignalForwarding bool
+}
+
+// Run runs the target command muting the output when matched the configuration,
+// same as Exec, and returns the Result of the run including the captured stdout/stderr.
+// The returned error is only for failures to execute the command, non zero exit codes
+// are reported in the Result. The command is canceled if ctx is done before it exits,
+// see CancelSignal, and the context error is returned.
+// Panics on empty Cmd.
+func (t *Target) Run(ctx context.Context) (*Result, error) {
+	result, ec := t.run(ctx)
+	defer ec.close()
+	result.Stdout = ec.Stdout.String()
+	result.Stderr = ec.Stderr.String()
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if ec.Error != nil && !errors.As(ec.Error, &exitErr) {
+		return result, ec.Error
+	}
+	return result, nil
 }
 
 // Exec runs the target command muting the output when matched the configuration
 // executes a command, checks the exit codes and matches stdout with patterns,
 // and writes the stdout/sterr when configuration did not match.
+// Timed out commands are never muted, and ExitErrTimeout is returned as the exit code.
 // Return the exit code of cmd, and an error if any.
 // Panics on empty Cmd.
 func (t *Target) Exec() (int, error) {
+	result, ec := t.run(context.Background())
+	ec.close()
+	return result.Status(), ec.Error
+}
+
+// run executes the command, writes the output if not muted, and returns the Result
+// and the execContext which should be closed by the caller
+func (t *Target) run(ctx context.Context) (*Result, *execContext) {
 	if t.Cmd == "" {
 		panic("target cmd is empty")
 	}
-	crt := cmdCriteria(t.Cmd, t.Conf)
-	ctx := execCmd(t.Cmd, t.Args, t.BufPreAlloc)
-	if !matchesCriteria(crt, ctx.ExitCode, ctx.StdoutText) {
-		fmt.Fprintf(t.OutWriter, "%v", *ctx.StdoutText)
-		fmt.Fprintf(t.ErrWriter, "%v", *ctx.StderrText)
+	crt, names := selectCriteria(t.Cmd, t.Args, t.Conf)
+	settings := cmdSettings(t.Cmd, t.Conf)
+	ec := t.execCmd(ctx, settings)
+	result := newResult(t.Cmd, t.Args, ec)
+	matched := false
+	if crt.usesState() || t.Conf.NeverMute.usesState() || settings.NotifyRecovery {
+		store := NewStateStore(settings.StateDir)
+		err := store.Update(t.stateKey(), func(state *RunState) error {
+			if ec.failed() {
+				ec.ConsecutiveFailures = state.ConsecutiveFailures + 1
+			}
+			if crt.mutesUnchanged() || t.Conf.NeverMute.mutesUnchanged() {
+				hash, err := outputHash(settings.OutputSubstitutions, ec.Stdout, ec.Stderr)
+				if err != nil {
+					return err
+				}
+				ec.OutputHash = hash
+			}
+			previous := *state
+			ec.previous = &previous
+			t.match(result, crt, names, ec)
+			matched = true
+			// a failing job succeeds again, not an unmuted run muted again (e.g. unchanged output)
+			result.Recovered = settings.NotifyRecovery && result.Muted && !ec.failed() &&
+				previous.ConsecutiveFailures > 0 && !previous.LastMuted
+			state.record(ec, result.Muted)
+			return nil
+		})
+		if errors.Is(err, ErrInvalidState) {
+			fmt.Fprintf(t.ErrWriter, "mute: warning: reset the state: %v\n", err)
+		} else if err != nil {
+			fmt.Fprintf(t.ErrWriter, "mute: failed to update state in %v: %v\n", store.Dir, err)
+		}
 	}
-	return ctx.ExitCode, ctx.Error
+	if !matched {
+		if ec.failed() {
+			ec.ConsecutiveFailures = 1
+		}
+		t.match(result, crt, names, ec)
+	}
+	notifiers := append(t.Conf.Notify.Notifiers(), t.Notifiers...)
+	if !result.Muted && (!settings.NotifyOnly || len(notifiers) == 0) {
+		if settings.OutputFormat == OutputFormatJSON {
+			stdout, stderr := ec.outputTail(settings.MaxBufferBytes)
+			report := NewRunReport(result, stdout, stderr, t.Conf.explain(t.Cmd, t.Args, ec))
+			if _, err := report.WriteTo(t.OutWriter); err != nil {
+				fmt.Fprintf(t.ErrWriter, "mute: failed to write the report: %v\n", err)
+			}
+		} else {
+			ec.writeOutput(t.OutWriter, t.ErrWriter, settings.MergeStreams)
+		}
+	}
+	if ec.TimedOut {
+		fmt.Fprintf(t.ErrWriter, "mute: %v timed out after %v\n", t.Cmd, settings.Timeout)
+	}
+	if result.Recovered {
+		out := t.OutWriter
+		if settings.OutputFormat == OutputFormatJSON { // keep stdout only JSON documents
+			out = t.ErrWriter
+		}
+		fmt.Fprintln(out, recoveryMessage(t.stateKey(), ec.previous))
+	}
+	// notify even if the command was canceled
+	t.notify(context.WithoutCancel(ctx), notifiers, result, ec, settings.MaxBufferBytes)
+	if settings.HistoryFile != "" {
+		maxBytes, keep := int64(DefaultHistoryMaxBytes), DefaultHistoryKeep
+		if settings.HistoryMaxBytes != 0 {
+			maxBytes = settings.HistoryMaxBytes
+		}
+		if settings.HistoryKeep != 0 {
+			keep = settings.HistoryKeep
+		}
+		if err := AppendHistory(settings.HistoryFile, maxBytes, keep, NewHistoryEntry(result)); err != nil {
+			fmt.Fprintf(t.ErrWriter, "mute: failed to append to history file %v: %v\n", settings.HistoryFile, err)
+		}
+	}
+	if settings.RecordDir != "" {
+		fixture := NewFixture(result)
+		fixture.Stdout, fixture.Stderr = ec.outputTail(settings.MaxBufferBytes)
+		if _, err := WriteFixture(settings.RecordDir, fixture); err != nil {
+			fmt.Fprintf(t.ErrWriter, "mute: failed to record the run in %v: %v\n", settings.RecordDir, err)
+		}
+	}
+	if settings.MetricsDir != "" {
+		if _, err := WriteMetrics(settings.MetricsDir, t.job(), result); err != nil {
+			fmt.Fprintf(t.ErrWriter, "mute: failed to write metrics in %v: %v\n", settings.MetricsDir, err)
+		}
+	}
+	return result, ec
+}
+
+// notify sends the notification of the run to the notifiers, muted runs only to the ones notified of muted runs.
+// The notification has the last maxBytes of the output (see execContext.outputTail).
+func (t *Target) notify(ctx context.Context, notifiers []Notifier, result *Result, ec *execContext, maxBytes int64) {
+	var notification *Notification
+	for _, notifier := range notifiers {
+		if result.Muted && !notifiesMuted(notifier) {
+			continue
+		}
+		if notification == nil {
+			stdout, stderr := ec.outputTail(maxBytes)
+			notification = NewNotification(result, stdout, stderr)
+		}
+		if err := notifier.Notify(ctx, notification); err != nil {
+			fmt.Fprintf(t.ErrWriter, "mute: failed to notify: %v\n", err)
+		}
+	}
+}
+
+// recoveryMessage returns the message to notify the command is muted again after the previous unmuted runs
+func recoveryMessage(key string, previous *RunState) string {
+	since := previous.FirstFailure
+	if since.IsZero() {
+		since = previous.LastAlert
+	}
+	return fmt.Sprintf("mute: recovered: %v succeeded after %d failures since %v",
+		key, previous.ConsecutiveFailures, since.Format(time.RFC3339))
 }
 
-// execCmd runs the command with args and returns a pointer to an execContext
-func execCmd(cmd string, args []string, bufPreAlloc int) *execContext {
-	var stdoutBuffer, stderrBuffer bytes.Buffer
-	if bufPreAlloc > 0 {
-		stdoutBuffer.Grow(bufPreAlloc)
-		stderrBuffer.Grow(bufPreAlloc)
+// errTimedOut is the cause of canceling the command context when the timeout expires
+var errTimedOut = errors.New("timed out")
+
+// match decides if the run should be muted matching the criteria, and updates the Result
+func (t *Target) match(result *Result, crt *Criteria, names []string, ec *execContext) {
+	result.ConsecutiveFailures = ec.ConsecutiveFailures
+	result.OutputHash = ec.OutputHash
+	if !ec.TimedOut {
+		result.Criterion = matchingCriterion(crt, ec)
+		result.CriterionName = criterionName(*crt, names, result.Criterion)
+		if result.Criterion != nil {
+			result.NeverMuteCriterion = matchingCriterion(&t.Conf.NeverMute, ec)
+			result.NeverMuteCriterionName = criterionName(t.Conf.NeverMute,
+				criteriaNames("never_mute", len(t.Conf.NeverMute)), result.NeverMuteCriterion)
+		}
 	}
-	var stdoutStr, stderrStr string
+	result.Muted = result.Criterion != nil && result.NeverMuteCriterion == nil
+}
+
+// stateKey returns the key of the command in the state store
+func (t *Target) stateKey() string {
+	if t.StateKey != "" {
+		return t.StateKey
+	}
+	return StateKey(t.Cmd, t.Args)
+}
+
+// job returns the job name of the target in metrics
+func (t *Target) job() string {
+	if t.Job != "" {
+		return t.Job
+	}
+	return JobName(t.Cmd)
+}
+
+// execCmd runs the target command and returns a pointer to an execContext
+// The command is sent the cancel signal (SIGTERM by default) when ctx is done or the timeout
+// from settings expires, and is killed (SIGKILL) if still running after the kill grace period.
+// Catchable signals received by the current process are forwarded to the command while it runs.
+// With process group settings, the command runs in its own process group, and signals are sent to the group.
+// The output is stored in spools limited by settings, that should be closed by the caller.
+// With ordered output (or merged streams) settings, the order of the output chunks is recorded.
+func (t *Target) execCmd(ctx context.Context, settings *Settings) *execContext {
+	stdout := newSpool(settings.MaxBufferBytes, settings.TruncateOutput, t.BufPreAlloc)
+	stderr := newSpool(settings.MaxBufferBytes, settings.TruncateOutput, t.BufPreAlloc)
 	var cmdExitCode int
 	var err error
-	var ctx = execContext{Cmd: cmd}
-	var sigs = make(chan os.Signal, 1)
-
-	execCmd := exec.Command(cmd, args...)
-	execCmd.Stdout = &stdoutBuffer
-	execCmd.Stderr = &stderrBuffer
-
-	go func() {
-		sig := <-sigs
-		if execCmd.Process != nil { // signal may arrive before cmd starts
-			if execCmd.Process.Signal(sig) != nil {
-				fmt.Fprintf(os.Stderr, "failed to send signal %v to process %d: %v\n", sig, execCmd.Process.Pid, err)
-			}
-		}
-	}()
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	var ec = execContext{Cmd: t.Cmd}
+
+	if settings.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, time.Duration(settings.Timeout), errTimedOut)
+		defer cancel()
+	}
+	command := exec.CommandContext(ctx, t.Cmd, t.Args...)
+	command.Stdout = stdout
+	command.Stderr = stderr
+	if settings.OrderedOutput || settings.MergeStreams {
+		ec.Chunks = new(chunkLog)
+		command.Stdout = &chunkWriter{stream: streamStdout, spool: stdout, log: ec.Chunks}
+		command.Stderr = &chunkWriter{stream: streamStderr, spool: stderr, log: ec.Chunks}
+	}
+	cancelSignal := t.CancelSignal
+	if cancelSignal == 0 {
+		cancelSignal = syscall.SIGTERM
+	}
+	killGrace := time.Duration(settings.KillGrace)
+	if killGrace <= 0 {
+		killGrace = DefaultKillGrace
+	}
+	canceled := false
+	command.Cancel = func() error {
+		// kill the command if still running after the grace period since canceled, and
+		// do not wait forever on pipes held open by child processes of a killed command
+		command.WaitDelay = killGrace
+		canceled = true
+		return signalCmd(command, cancelSignal, settings.ProcessGroup)
+	}
+	if settings.ProcessGroup {
+		command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
 
-	if err = execCmd.Run(); err != nil {
+	started := make(chan struct{})
+	done := make(chan struct{})
+	if !t.DisableSignalForwarding {
+		sigs := make(chan os.Signal, len(forwardedSignals))
+		signal.Notify(sigs, forwardedSignals...)
+		defer signal.Stop(sigs)
+		go forwardSignals(command, sigs, settings.ProcessGroup, started, done)
+	}
+
+	ec.StartTime = time.Now()
+	if err = command.Start(); err == nil {
+		close(started)
+		err = command.Wait()
+		if errors.Is(err, exec.ErrWaitDelay) && command.ProcessState.Success() {
+			err = nil // exited cleanly, only its child processes held the pipes open
+		}
+	}
+	close(done)
+	if canceled && settings.ProcessGroup {
+		// WaitDelay only kills the command, kill the rest of the group that survived the cancel signal
+		_ = signalCmd(command, syscall.SIGKILL, true)
+	}
+	ec.Duration = time.Since(ec.StartTime)
+	if err != nil {
 		switch e := err.(type) {
 		case *exec.ExitError:
 			cmdExitCode = e.ExitCode()
+			if status, ok := e.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				ec.Signal = status.Signal()
+			}
 		default:
 			cmdExitCode = ExitErrExec
 		}
 	}
-	stdoutStr = stdoutBuffer.String()
-	stdoutBuffer.Reset()
-	stderrStr = stderrBuffer.String()
-	stderrBuffer.Reset()
-	ctx.ExitCode = cmdExitCode
-	ctx.StdoutText = &stdoutStr
-	ctx.StderrText = &stderrStr
-	ctx.Error = err
-	return &ctx
+	ec.ExitCode = cmdExitCode
+	ec.Stdout = stdout
+	ec.Stderr = stderr
+	ec.Error = err
+	ec.TimedOut = errors.Is(context.Cause(ctx), errTimedOut)
+	return &ec
+}
+
+// forwardSignals sends the signals received on sigs to the command (or its process group)
+// after the command is started, until done is closed
+func forwardSignals(command *exec.Cmd, sigs <-chan os.Signal, group bool, started, done <-chan struct{}) {
+	select { // signals are buffered until the command is started
+	case <-started:
+	case <-done:
+		return
+	}
+	for {
+		select {
+		case sig := <-sigs:
+			if err := signalCmd(command, sig, group); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to send signal %v to process %d: %v\n", sig, command.Process.Pid, err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// signalCmd sends the signal to the started command, or to its process group
+func signalCmd(command *exec.Cmd, sig os.Signal, group bool) error {
+	if s, ok := sig.(syscall.Signal); ok && group {
+		return syscall.Kill(-command.Process.Pid, s)
+	}
+	return command.Process.Signal(sig)
 }
 
 // matchesCriteria indicates if results of an exec matches a given Criteria
-// to decide if a program should be muted or not, its exit code and stdout/stderr is matched
+// to decide if a program should be muted or not, its exit code, signal and stdout/stderr is matched
 // against the configured Criteria. This function helps to decide on mute or not
-func matchesCriteria(criteria *Criteria, code int, stdout *string) bool {
+func matchesCriteria(criteria *Criteria, ec *execContext) bool {
+	return matchingCriterion(criteria, ec) != nil
+}
+
+// matchingCriterion returns the first Criterion of the Criteria that matches results of an exec,
+// or nil if none matched
+func matchingCriterion(criteria *Criteria, ec *execContext) *Criterion {
 	for _, crt := range *criteria {
-		if crt.IsEmpty() {
-			continue
+		if evalCriterion(crt, ec) == "" {
+			return crt
 		}
-		if len(crt.ExitCodes) < 1 || codesContain(crt.ExitCodes, code) {
-			if len(crt.StdoutPatterns) < 1 || stdoutMatches(crt.StdoutPatterns, stdout) {
-				return true
-			}
+	}
+	return nil
+}
+
+// evalCriterion matches the Criterion with results of an exec, and returns the reason it did not match,
+// or an empty string if it matched
+func evalCriterion(crt *Criterion, ec *execContext) string {
+	if crt.IsEmpty() {
+		return "empty criterion"
+	}
+	if len(crt.ExitCodes) > 0 && !codesContain(crt.ExitCodes, ec.ExitCode) {
+		return fmt.Sprintf("exit code %d is not in %v", ec.ExitCode, crt.ExitCodes)
+	}
+	if len(crt.Signals) > 0 && (ec.Signal == 0 || !signalsContain(crt.Signals, ec.Signal)) {
+		if ec.Signal == 0 {
+			return fmt.Sprintf("not terminated by any of signals %v", crt.Signals)
+		}
+		return fmt.Sprintf("signal %v is not in %v", SignalName(ec.Signal), crt.Signals)
+	}
+	if len(crt.StdoutPatterns) > 0 && matchingPattern(crt.StdoutPatterns, ec.Stdout) == nil {
+		return fmt.Sprintf("stdout does not match any of patterns %v", crt.StdoutPatterns)
+	}
+	if len(crt.StderrPatterns) > 0 && matchingPattern(crt.StderrPatterns, ec.Stderr) == nil {
+		return fmt.Sprintf("stderr does not match any of patterns %v", crt.StderrPatterns)
+	}
+	if p := matchingPattern(crt.StdoutExcludePatterns, ec.Stdout); p != nil {
+		return fmt.Sprintf("stdout matches exclude pattern %q", p)
+	}
+	if p := matchingPattern(crt.StderrExcludePatterns, ec.Stderr); p != nil {
+		return fmt.Sprintf("stderr matches exclude pattern %q", p)
+	}
+	if crt.UnmuteAfterConsecutiveFailures > 0 && ec.ConsecutiveFailures >= crt.UnmuteAfterConsecutiveFailures {
+		return fmt.Sprintf("failed %d times in a row, unmuted after %d",
+			ec.ConsecutiveFailures, crt.UnmuteAfterConsecutiveFailures)
+	}
+	if crt.MuteIfUnchanged && !ec.outputUnchanged(time.Duration(crt.RealertAfter)) {
+		if ec.previous == nil || ec.previous.OutputHash == "" {
+			return "no previous output to compare"
 		}
+		return "output changed from the previous run, or realert duration passed"
 	}
-	return false
+	return ""
 }
 
 // cmdCriteria returns the Criteria that the cmd should be matched against from the Conf
 // Each command is matched against a criteria. The Conf has Criterias
 // either per command or a default one that is used for all commands.
 // cmdCriteria finds the corresponding Criterian from a Conf that the cmd
-// should be checked against
-func cmdCriteria(cmd string, conf *Conf) *Criteria {
+// should be checked against. The first Rule matching the command and its arguments
+// takes precedence, then the longest command prefix, then the default.
+// Command criteria are followed by the default criteria, if they inherit the default.
+func cmdCriteria(cmd string, args []string, conf *Conf) *Criteria {
+	criteria, _ := selectCriteria(cmd, args, conf)
+	return criteria
+}
+
+// selectCriteria returns the Criteria of the cmd like cmdCriteria, and the config names of each Criterion
+// (e.g. "rules[0].criteria[1]", "commands.backup[0]", "default[2]")
+func selectCriteria(cmd string, args []string, conf *Conf) (*Criteria, []string) {
+	var criteria Criteria
+	var name string
+	for i := range conf.Rules {
+		if conf.Rules[i].matches(cmd, args) {
+			criteria, name = conf.Rules[i].Criteria, fmt.Sprintf("rules[%d].criteria", i)
+			break
+		}
+	}
+	if name == "" {
+		matched := longestPrefixKey(cmd, conf.Commands)
+		if matched == "" { // no command specific criteria matched cmd
+			return &conf.Default, criteriaNames("default", len(conf.Default))
+		}
+		criteria, name = conf.Commands[matched], "commands."+matched
+	}
+	names := criteriaNames(name, len(criteria))
+	if criteria.inheritsDefault() || cmdSettings(cmd, conf).CommandsInheritDefault {
+		criteria = append(append(Criteria{}, criteria...), conf.Default...)
+		names = append(names, criteriaNames("default", len(conf.Default))...)
+	}
+	return &criteria, names
+}
+
+// criterionName returns the config name of the Criterion in the criteria, empty if not found
+func criterionName(criteria Criteria, names []string, crt *Criterion) string {
+	for i, item := range criteria {
+		if item == crt {
+			return names[i]
+		}
+	}
+	return ""
+}
+
+// criteriaNames returns the config names of n Criterion items of the criteria name
+func criteriaNames(name string, n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("%v[%d]", name, i)
+	}
+	return names
+}
+
+// cmdSettings returns the Settings that the cmd should be executed with from the Conf
+// The global Settings are overridden by the values set in the longest matching command settings.
+func cmdSettings(cmd string, conf *Conf) *Settings {
+	settings := conf.Settings
+	if matched := longestPrefixKey(cmd, conf.CommandSettings); matched != "" {
+		cmdSettings := conf.CommandSettings[matched]
+		settings.merge(&cmdSettings)
+	}
+	return &settings
+}
+
+// longestPrefixKey returns the longest key of the map that cmd starts with, or empty string
+func longestPrefixKey[V any](cmd string, m map[string]V) string {
 	matched := ""
-	for key := range conf.Commands {
+	for key := range m {
 		if len(key) > len(matched) && strings.HasPrefix(cmd, key) {
 			matched = key
 		}
 	}
-	if matched == "" { // no command specific criteria matched cmd
-		return &conf.Default
-	}
-	criteria := conf.Commands[matched]
-	return &criteria
+	return matched
+}
+
+// stdoutMatches checks if output (stdout or stderr) matches any of the specified StdoutPattern regex patterns
+func stdoutMatches(patterns []*StdoutPattern, stdout *spool) bool {
+	return matchingPattern(patterns, stdout) != nil
 }
 
-// stdoutMatches checks if string matches any of the specified StdoutPattern regex patterns
-func stdoutMatches(patterns []*StdoutPattern, stdout *string) bool {
+// matchingPattern returns the first of the StdoutPattern regex patterns that output matches, or nil
+func matchingPattern(patterns []*StdoutPattern, output *spool) *StdoutPattern {
 	for _, p := range patterns {
-		if p.Regexp.MatchString(*stdout) {
-			return true
+		if output.MatchRegexp(p.Regexp) {
+			return p
 		}
 	}
-	return false
+	return nil
 }
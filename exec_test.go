@@ -5,7 +5,15 @@ package mute
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestExecMute(t *testing.T) {
@@ -56,12 +64,228 @@ func TestExecNoMute(t *testing.T) {
 	}
 }
 
+func TestRunResult(t *testing.T) {
+	conf, _ := ReadConfFile("test/data/simple.toml")
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Args: []string{"-c", "2", "OK"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	result, err := target.Run(context.Background())
+	if err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+	if !result.Muted || result.ExitCode != 2 || result.Status() != 2 {
+		t.Errorf("Run want muted with exit code 2, got: %v", result)
+	}
+	if !result.Criterion.equal(NewCriterion([]int{1, 2}, []string{"OK"})) {
+		t.Errorf("Run want matched criterion for exit codes 1,2 got: %v", result.Criterion)
+	}
+	if result.Stdout != "OK\n" || result.StdoutBytes != 3 || result.Stderr != "" {
+		t.Errorf("Run want captured stdout 'OK', got: %v", result)
+	}
+	if outBuf.Len() != 0 {
+		t.Errorf("Run muted should not print output, got: %v", outBuf.String())
+	}
+	if result.Duration <= 0 || result.StartTime.IsZero() {
+		t.Errorf("Run want duration and start time, got: %v", result)
+	}
+
+	target.Args = []string{"-c", "3", "failed"}
+	result, err = target.Run(context.Background())
+	if err != nil {
+		t.Errorf("Run non zero exit code returned error: %v", err)
+	}
+	if result.Muted || result.Criterion != nil || result.ExitCode != 3 {
+		t.Errorf("Run want not muted with exit code 3, got: %v", result)
+	}
+	if outBuf.String() != "failed\n" {
+		t.Errorf("Run not muted should print output, got: %v", outBuf.String())
+	}
+
+	target.Cmd = "test/data/no_such_command"
+	if _, err = target.Run(context.Background()); err == nil {
+		t.Errorf("Run invalid command should return error")
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	conf := DefaultConf()
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "sleep", Args: []string{"5"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf, DisableSignalForwarding: true}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, err := target.Run(ctx)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Run cancel took too long: %v", elapsed)
+	}
+	if err != context.DeadlineExceeded {
+		t.Errorf("Run cancel want context error, got: %v", err)
+	}
+	if result.Signal != syscall.SIGTERM || result.TimedOut {
+		t.Errorf("Run cancel want terminated by SIGTERM not timed out, got: %v", result)
+	}
+
+	target.CancelSignal = syscall.SIGKILL
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err = target.Run(ctx); err != context.Canceled {
+		t.Errorf("Run canceled context want context error, got: %v", err)
+	}
+}
+
+func TestRunForwardSignals(t *testing.T) {
+	conf := DefaultConf()
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	script := "trap 'echo got usr1; exit 3' USR1; sleep 5 & wait"
+	target := Target{Cmd: "bash", Args: []string{"-c", script}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	conf.Settings = Settings{ProcessGroup: true}
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	}()
+	result, _ := target.Run(context.Background())
+	if result.ExitCode != 3 || result.Stdout != "got usr1\n" {
+		t.Errorf("Run forward signals want exit code 3 and output, got: %v", result)
+	}
+}
+
+func TestRunProcessGroupTimeout(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings = Settings{ProcessGroup: true, Timeout: Duration(200 * time.Millisecond), KillGrace: Duration(100 * time.Millisecond)}
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "bash", Args: []string{"-c", "sleep 5 & echo $!; wait"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	result, _ := target.Run(context.Background())
+	if !result.TimedOut {
+		t.Errorf("Run process group want timed out, got: %v", result)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(result.Stdout))
+	if err != nil {
+		t.Fatalf("Run process group want pid of child in output, got: %v", result.Stdout)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if processRunning(pid) {
+		t.Errorf("Run process group should have terminated child process %d", pid)
+	}
+}
+
+func TestRunProcessGroupTimeoutIgnoringTerm(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings = Settings{ProcessGroup: true, Timeout: Duration(200 * time.Millisecond), KillGrace: Duration(100 * time.Millisecond)}
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	// the grandchild does not hold the pipes, so the command is waited as soon as it is terminated
+	script := `sh -c 'trap "" TERM; echo $$; exec sleep 30 > /dev/null 2>&1' & wait`
+	target := Target{Cmd: "sh", Args: []string{"-c", script}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	result, _ := target.Run(context.Background())
+	if !result.TimedOut {
+		t.Errorf("Run process group want timed out, got: %v", result)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(result.Stdout))
+	if err != nil {
+		t.Fatalf("Run process group want pid of grandchild in output, got: %v", result.Stdout)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if processRunning(pid) {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Errorf("Run process group should have killed grandchild process %d ignoring SIGTERM", pid)
+	}
+}
+
+func TestExecTimeoutBackgroundChild(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings = Settings{Timeout: Duration(time.Minute), KillGrace: Duration(100 * time.Millisecond)}
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "sh", Args: []string{"-c", "(sleep 1 &); echo hi"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	result, err := target.Run(context.Background())
+	if err != nil || result.ExitCode != 0 || result.TimedOut || result.Stdout != "hi\n" {
+		t.Errorf("Run with a background child holding stdout want exit code 0 and output, got: %v %v", result, err)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	conf := new(Conf)
+	conf.Default.add(NewCriterion([]int{}, []string{".*"}))
+	conf.Settings = Settings{Timeout: Duration(100 * time.Millisecond), KillGrace: Duration(100 * time.Millisecond)}
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "sleep", Args: []string{"5"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	start := time.Now()
+	code, _ := target.Exec()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Exec timeout took too long: %v", elapsed)
+	}
+	if code != ExitErrTimeout {
+		t.Errorf("Exec timeout return val. got: %d want: %d", code, ExitErrTimeout)
+	}
+	if !strings.Contains(errBuf.String(), "timed out after 100ms") {
+		t.Errorf("Exec timeout should print timeout notice, got: %v", errBuf.String())
+	}
+}
+
+func TestExecMaxBuffer(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings = Settings{MaxBufferBytes: 8}
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Args: []string{"-c", "1", "output longer than max buffer"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	code, _ := target.Exec()
+	if code != 1 {
+		t.Errorf("Exec max buffer return val. got: %d want: 1", code)
+	}
+	if got := outBuf.String(); got != "output longer than max buffer\n" {
+		t.Errorf("Exec max buffer should print all output, got: %v", got)
+	}
+
+	conf.Settings.TruncateOutput = true
+	outBuf.Reset()
+	target.Args = []string{"-c", "1", "head and the tail"}
+	target.Exec()
+	if got := outBuf.String(); got != "head\n[mute: truncated 10 bytes]\nail\n" {
+		t.Errorf("Exec truncate output should print head and tail, got: %v", got)
+	}
+}
+
+func TestExecMergeStreams(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings = Settings{MergeStreams: true}
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	script := "echo out1; sleep 0.05; echo err1 >&2; sleep 0.05; echo out2; exit 1"
+	target := Target{Cmd: "bash", Args: []string{"-c", script}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	target.Exec()
+	if got := outBuf.String(); got != "out1\nerr1\nout2\n" {
+		t.Errorf("Exec merge streams want interleaved output, got: %v", got)
+	}
+	if errBuf.Len() != 0 {
+		t.Errorf("Exec merge streams should not write to error writer, got: %v", errBuf.String())
+	}
+}
+
+func TestCmdSettings(t *testing.T) {
+	conf := new(Conf)
+	conf.Settings = Settings{Timeout: Duration(time.Minute), KillGrace: Duration(time.Second)}
+	conf.CommandSettings = map[string]Settings{"back": {Timeout: Duration(time.Hour)}, "backup": {KillGrace: Duration(time.Minute)}}
+
+	got := cmdSettings("testcommand", conf)
+	if !got.equal(&conf.Settings) {
+		t.Errorf("cmdSettings should have returned global settings, got %v", got)
+	}
+	got = cmdSettings("backup.sh", conf)
+	if got.Timeout != Duration(time.Minute) || got.KillGrace != Duration(time.Minute) {
+		t.Errorf("cmdSettings should have merged longest matched cmd settings, got %v", got)
+	}
+}
+
 func TestCmdCriteriaReturnDefault(t *testing.T) {
 	c1 := NewCriterion([]int{0}, []string{})
 	conf := new(Conf)
 	conf.Default.add(c1)
 
-	got := cmdCriteria("testcommand", conf)
+	got := cmdCriteria("testcommand", nil, conf)
 	if !got.equal(&conf.Default) {
 		t.Errorf("cmdCriteria should have returned conf default but didn't")
 	}
@@ -82,7 +306,7 @@ func TestCmdCriteriaReturnCommandSpecific(t *testing.T) {
 	conf := new(Conf)
 	conf.Commands = commandsCriteria
 
-	got := cmdCriteria("testcommand", conf)
+	got := cmdCriteria("testcommand", nil, conf)
 	if !got.equal(&crt2) {
 		t.Errorf("cmdCriteria should have returned longest matched cmd but didn't")
 	}
@@ -91,18 +315,490 @@ func TestCmdCriteriaReturnCommandSpecific(t *testing.T) {
 func TestMatchesCriteria(t *testing.T) {
 	conf, _ := ReadConfFile("test/data/simple.toml")
 	crt := conf.Default
-	stdout := ""
-	if !matchesCriteria(&crt, 0, &stdout) {
+	stdout := newSpoolString("")
+	stderr := newSpoolString("")
+	if !matchesCriteria(&crt, testExecContext(0, stdout, stderr)) {
 		t.Errorf("matchesCriteria 0 default want 'true' got 'false'")
 	}
-	if matchesCriteria(&crt, 3, &stdout) {
+	if matchesCriteria(&crt, testExecContext(3, stdout, stderr)) {
 		t.Errorf("matchesCriteria 3 default want 'false' got 'true'")
 	}
-	if matchesCriteria(&crt, 1, &stdout) {
+	if matchesCriteria(&crt, testExecContext(1, stdout, stderr)) {
 		t.Errorf("matchesCriteria 1 empty stdout want 'false' got 'true'")
 	}
-	stdout = "OK"
-	if !matchesCriteria(&crt, 1, &stdout) {
+	stdout = newSpoolString("OK")
+	if !matchesCriteria(&crt, testExecContext(1, stdout, stderr)) {
 		t.Errorf("matchesCriteria 1 matching stdout want 'true' got 'false'")
 	}
 }
+
+func TestMatchesCriteriaStderr(t *testing.T) {
+	conf, err := ReadConfFile("test/data/stderr.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile stderr had error: %v", err)
+	}
+	crt := conf.Default
+	stdout := newSpoolString("")
+	stderr := newSpoolString("INFO: all good")
+	if !matchesCriteria(&crt, testExecContext(0, stdout, stderr)) {
+		t.Errorf("matchesCriteria 0 matching stderr want 'true' got 'false'")
+	}
+	if matchesCriteria(&crt, testExecContext(1, stdout, stderr)) {
+		t.Errorf("matchesCriteria 1 matching stderr want 'false' got 'true'")
+	}
+	stderr = newSpoolString("ERROR: failed")
+	if matchesCriteria(&crt, testExecContext(0, stdout, stderr)) {
+		t.Errorf("matchesCriteria 0 unmatched stderr want 'false' got 'true'")
+	}
+	stdout = newSpoolString("OK")
+	if matchesCriteria(&crt, testExecContext(0, stdout, stderr)) {
+		t.Errorf("matchesCriteria 0 matching stdout unmatched stderr want 'false' got 'true'")
+	}
+	stderr = newSpoolString("INFO: all good")
+	if !matchesCriteria(&crt, testExecContext(2, stdout, stderr)) {
+		t.Errorf("matchesCriteria 2 matching stdout and stderr want 'true' got 'false'")
+	}
+}
+
+func TestMatchesCriteriaExclude(t *testing.T) {
+	conf, err := ReadConfFile("test/data/exclude.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile exclude had error: %v", err)
+	}
+	crt := conf.Default
+	stdout := newSpoolString("all good")
+	stderr := newSpoolString("")
+	if !matchesCriteria(&crt, testExecContext(0, stdout, stderr)) {
+		t.Errorf("matchesCriteria 0 no exclusion want 'true' got 'false'")
+	}
+	stdout = newSpoolString("this flag is deprecated")
+	if matchesCriteria(&crt, testExecContext(0, stdout, stderr)) {
+		t.Errorf("matchesCriteria 0 excluded stdout want 'false' got 'true'")
+	}
+	stdout = newSpoolString("all good")
+	stderr = newSpoolString("ERROR: failed")
+	if matchesCriteria(&crt, testExecContext(0, stdout, stderr)) {
+		t.Errorf("matchesCriteria 0 excluded stderr want 'false' got 'true'")
+	}
+}
+
+func TestExecNeverMute(t *testing.T) {
+	conf := DefaultConf()
+	conf.NeverMute.add(NewCriterion([]int{}, []string{"go version"}))
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "go", Args: []string{"version"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	code, err := target.Exec()
+	if err != nil {
+		t.Errorf("Exec returned error: %v", err)
+	}
+	if code != 0 {
+		t.Errorf("Exec return val. got: %d want: 0", code)
+	}
+	if outBuf.String() == "" {
+		t.Errorf("Exec never mute should print output but didn't")
+	}
+}
+
+func TestMatchesCriteriaSignals(t *testing.T) {
+	conf, err := ReadConfFile("test/data/signals.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile signals had error: %v", err)
+	}
+	crt := conf.Default
+	ec := testExecContext(-1, newSpoolString(""), newSpoolString(""))
+	ec.Signal = syscall.SIGPIPE
+	if !matchesCriteria(&crt, ec) {
+		t.Errorf("matchesCriteria SIGPIPE want 'true' got 'false'")
+	}
+	ec.Signal = syscall.SIGKILL
+	if matchesCriteria(&crt, ec) {
+		t.Errorf("matchesCriteria SIGKILL want 'false' got 'true'")
+	}
+	ec.Signal = 0
+	if matchesCriteria(&crt, ec) {
+		t.Errorf("matchesCriteria no signal want 'false' got 'true'")
+	}
+}
+
+func TestExecSignalStatus(t *testing.T) {
+	conf := DefaultConf()
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "bash", Args: []string{"-c", "kill -TERM $$"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	code, _ := target.Exec()
+	if code != 128+int(syscall.SIGTERM) {
+		t.Errorf("Exec terminated by signal return val. got: %d want: %d", code, 128+int(syscall.SIGTERM))
+	}
+
+	conf.Default.add(new(Criterion).AddSignals(syscall.SIGTERM))
+	result, _ := target.Run(context.Background())
+	if !result.Muted || result.Signal != syscall.SIGTERM {
+		t.Errorf("Run terminated by muted signal want muted, got: %v", result)
+	}
+}
+
+// testExecContext returns an execContext with the exit code and output for testing
+func testExecContext(code int, stdout, stderr *spool) *execContext {
+	return &execContext{ExitCode: code, Stdout: stdout, Stderr: stderr}
+}
+
+// processRunning checks if the process is running (exists and is not a zombie waiting to be reaped)
+func processRunning(pid int) bool {
+	if syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	return err != nil || !strings.Contains(string(stat), ") Z ")
+}
+
+func TestExecUnmuteAfterConsecutiveFailures(t *testing.T) {
+	conf := DefaultConf()
+	conf.Default.add(&Criterion{ExitCodes: []int{3}, UnmuteAfterConsecutiveFailures: 3})
+	conf.Settings.StateDir = t.TempDir()
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Args: []string{"-c", "3", "flaky"}, Conf: conf,
+		OutWriter: &outBuf, ErrWriter: &errBuf}
+	for run := 1; run <= 3; run++ {
+		outBuf.Reset()
+		result, _ := target.Run(context.Background())
+		if result.ConsecutiveFailures != run {
+			t.Errorf("Run %d consecutive failures got: %d", run, result.ConsecutiveFailures)
+		}
+		if muted := run < 3; result.Muted != muted {
+			t.Errorf("Run %d muted want: %v got: %v", run, muted, result.Muted)
+		}
+	}
+	if !strings.Contains(outBuf.String(), "flaky") {
+		t.Errorf("Run failure threshold should print output, got: %q", outBuf.String())
+	}
+
+	target.Args = []string{"recovered"}
+	result, _ := target.Run(context.Background())
+	if result.ConsecutiveFailures != 0 || !result.Muted {
+		t.Errorf("Run success should reset failures and mute, got: %v", result)
+	}
+	target.Args = []string{"-c", "3", "flaky"}
+	target.StateKey = "flaky job"
+	if result, _ = target.Run(context.Background()); result.ConsecutiveFailures != 1 || !result.Muted {
+		t.Errorf("Run after success want muted with 1 failure, got: %v", result)
+	}
+	if errBuf.String() != "" {
+		t.Errorf("Run with state should not print errors, got: %q", errBuf.String())
+	}
+}
+
+func TestExecMuteIfUnchanged(t *testing.T) {
+	conf := DefaultConf()
+	conf.Default = Criteria{&Criterion{MuteIfUnchanged: true}}
+	conf.Settings.StateDir = t.TempDir()
+	conf.Settings.OutputSubstitutions = []Substitution{{Pattern: NewStdoutPattern(`run \d+`), Replace: "run N"}}
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf, StateKey: "monitor"}
+	for i, run := range []struct {
+		msg   string
+		muted bool
+	}{{"run 1 disk full", false}, {"run 2 disk full", true}, {"run 3 disk ok", false}, {"run 4 disk ok", true}} {
+		target.Args = []string{"-c", "1", run.msg}
+		result, _ := target.Run(context.Background())
+		if result.Muted != run.muted {
+			t.Errorf("Run %d %q muted want: %v got: %v", i, run.msg, run.muted, result.Muted)
+		}
+	}
+
+	conf.Default[0].RealertAfter = Duration(time.Nanosecond)
+	if result, _ := target.Run(context.Background()); result.Muted {
+		t.Errorf("Run unchanged output after realert duration want not muted, got muted")
+	}
+	if errBuf.String() != "" {
+		t.Errorf("Run with state should not print errors, got: %q", errBuf.String())
+	}
+}
+
+func TestExecNotifyRecovery(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings.StateDir = t.TempDir()
+	conf.Settings.NotifyRecovery = true
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf, StateKey: "backup"}
+	for i, run := range []struct {
+		code      string
+		recovered bool
+	}{{"0", false}, {"1", false}, {"2", false}, {"0", true}, {"0", false}} {
+		outBuf.Reset()
+		target.Args = []string{"-c", run.code, "done"}
+		result, _ := target.Run(context.Background())
+		if result.Recovered != run.recovered {
+			t.Errorf("Run %d exit code %v recovered want: %v got: %v", i, run.code, run.recovered, result.Recovered)
+		}
+		if run.recovered && !strings.HasPrefix(outBuf.String(), "mute: recovered: backup succeeded after 2 failures since ") {
+			t.Errorf("Run %d recovered want recovery message got: %q", i, outBuf.String())
+		}
+	}
+}
+
+func TestExecNotifyRecoveryJSON(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings.StateDir = t.TempDir()
+	conf.Settings.NotifyRecovery = true
+	conf.Settings.OutputFormat = OutputFormatJSON
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Args: []string{"-c", "1", "failed"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf,
+		StateKey: "backup"}
+	target.Run(context.Background())
+	var report RunReport
+	if err := json.Unmarshal(outBuf.Bytes(), &report); err != nil || report.ExitCode != 1 {
+		t.Errorf("Run json format failed want a JSON document, got: %q %v", outBuf.String(), err)
+	}
+	outBuf.Reset()
+	target.Args = []string{"-c", "0", "done"}
+	if result, _ := target.Run(context.Background()); !result.Recovered {
+		t.Errorf("Run json format succeeded again want recovered")
+	}
+	if outBuf.Len() != 0 || !strings.HasPrefix(errBuf.String(), "mute: recovered: ") {
+		t.Errorf("Run json format want recovery message on stderr only, got stdout: %q stderr: %q", outBuf.String(), errBuf.String())
+	}
+}
+
+func TestExecNotifyRecoveryUnchanged(t *testing.T) {
+	conf := new(Conf)
+	conf.Default.add(&Criterion{MuteIfUnchanged: true})
+	conf.Settings.StateDir = t.TempDir()
+	conf.Settings.NotifyRecovery = true
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Args: []string{"same warning"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	for i, muted := range []bool{false, true, true} {
+		outBuf.Reset()
+		result, _ := target.Run(context.Background())
+		if result.Muted != muted || result.Recovered {
+			t.Errorf("Run %d unchanged output want muted %v not recovered, got muted %v recovered %v",
+				i, muted, result.Muted, result.Recovered)
+		}
+		if muted && outBuf.Len() != 0 {
+			t.Errorf("Run %d unchanged output want no recovery message, got: %q", i, outBuf.String())
+		}
+	}
+}
+
+func TestCmdCriteriaRules(t *testing.T) {
+	conf, err := ReadConfFile("test/data/rules.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile rules had error: %v", err)
+	}
+	for _, tc := range []struct {
+		cmd   string
+		args  []string
+		codes []int
+	}{
+		{"/usr/local/bin/backup.sh", []string{"--full", "/home"}, []int{0, 1}},
+		{"backup.sh", []string{"--incremental"}, []int{3}},
+		{"/usr/bin/rsync", []string{"-a"}, []int{0, 24}},
+		{"/usr/bin/rsync2", nil, []int{0}},
+		{"/opt/jobs/cleanup", nil, []int{0, 2}},
+		{"cleanup", nil, []int{0}},
+	} {
+		got := cmdCriteria(tc.cmd, tc.args, conf)
+		if len(*got) != 1 || len((*got)[0].ExitCodes) != len(tc.codes) || (*got)[0].ExitCodes[0] != tc.codes[0] {
+			t.Errorf("cmdCriteria %v %v want exit codes %v got %v", tc.cmd, tc.args, tc.codes, got)
+		}
+	}
+}
+
+func TestCmdCriteriaInheritDefault(t *testing.T) {
+	conf, err := ReadConfFile("test/data/inherit.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile inherit had error: %v", err)
+	}
+	want := Criteria{&Criterion{ExitCodes: []int{3}, InheritDefault: true}, NewCriterion([]int{0}, nil)}
+	if got := cmdCriteria("backup", nil, conf); !got.equal(&want) {
+		t.Errorf("cmdCriteria inherit default want %v got %v", want, got)
+	}
+	if !matchesCriteria(cmdCriteria("backup", nil, conf), testExecContext(0, newSpoolString(""), newSpoolString(""))) {
+		t.Errorf("matchesCriteria inherited default exit code 0 want 'true' got 'false'")
+	}
+	want = Criteria{NewCriterion([]int{24}, nil)}
+	if got := cmdCriteria("rsync", nil, conf); !got.equal(&want) {
+		t.Errorf("cmdCriteria no inherit default want %v got %v", want, got)
+	}
+
+	conf.Settings.CommandsInheritDefault = true
+	want = Criteria{NewCriterion([]int{24}, nil), NewCriterion([]int{0}, nil)}
+	if got := cmdCriteria("rsync", nil, conf); !got.equal(&want) {
+		t.Errorf("cmdCriteria commands inherit default setting want %v got %v", want, got)
+	}
+	if got := cmdCriteria("other", nil, conf); !got.equal(&conf.Default) {
+		t.Errorf("cmdCriteria default with inherit setting want %v got %v", conf.Default, got)
+	}
+}
+
+func TestExecRecordDir(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings.RecordDir = t.TempDir()
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Args: []string{"-c", "2", "recorded"}, Conf: conf,
+		OutWriter: &outBuf, ErrWriter: &errBuf}
+	if code, _ := target.Exec(); code != 2 {
+		t.Errorf("Exec record return val. got: %d want: 2", code)
+	}
+	paths, _ := filepath.Glob(filepath.Join(conf.Settings.RecordDir, "*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("Exec record want 1 fixture file, got: %v", paths)
+	}
+	fixture, err := ReadFixture(paths[0])
+	if err != nil {
+		t.Fatalf("ReadFixture had error: %v", err)
+	}
+	if fixture.ExitCode != 2 || fixture.Stdout != "recorded\n" || fixture.Muted || fixture.Cmd != "test/data/xecho" {
+		t.Errorf("Exec record fixture want exit code 2, stdout 'recorded', not muted got: %+v", fixture)
+	}
+}
+
+func TestExecHistoryFile(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings.HistoryFile = filepath.Join(t.TempDir(), "history.jsonl")
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Args: []string{"history"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	if _, err := target.Exec(); err != nil {
+		t.Fatalf("Exec had error: %v", err)
+	}
+	entries, err := ReadHistory(conf.Settings.HistoryFile, nil)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ReadHistory want 1 entry, got: %v %v", entries, err)
+	}
+	if e := entries[0]; !e.Muted || e.Criterion != "default[0]" || e.StdoutBytes != 8 || e.EndTime.Before(e.StartTime) {
+		t.Errorf("Exec history entry want muted by default[0] with 8 stdout bytes, got: %+v", e)
+	}
+}
+
+func TestExecMetricsDir(t *testing.T) {
+	conf := DefaultConf()
+	conf.Settings.MetricsDir = t.TempDir()
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	target := Target{Cmd: "test/data/xecho", Args: []string{"-c", "2", "failed"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	if exitCode, _ := target.Exec(); exitCode != 2 {
+		t.Fatalf("Exec want exit code 2, got: %v", exitCode)
+	}
+	content, err := os.ReadFile(filepath.Join(conf.Settings.MetricsDir, "xecho.prom"))
+	if err != nil {
+		t.Fatalf("Exec want metrics file named by the command basename, got: %v", err)
+	}
+	for _, want := range []string{`mute_job_last_exit_code{mute_job="xecho"} 2`, `mute_job_muted{mute_job="xecho"} 0`, `mute_job_stdout_bytes{mute_job="xecho"} 7`} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Exec metrics want %q, got: %s", want, content)
+		}
+	}
+	target.Job = "nightly"
+	target.Exec()
+	if _, err = os.Stat(filepath.Join(conf.Settings.MetricsDir, "nightly.prom")); err != nil {
+		t.Errorf("Exec want metrics file named by the job, got: %v", err)
+	}
+}
+
+// testNotifier records the notifications
+type testNotifier struct {
+	notifications []*Notification
+}
+
+func (n *testNotifier) Notify(ctx context.Context, notification *Notification) error {
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+func TestExecNotifiers(t *testing.T) {
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	notifier := new(testNotifier)
+	target := Target{Cmd: "test/data/xecho", Args: []string{"muted"}, Conf: DefaultConf(), OutWriter: &outBuf, ErrWriter: &errBuf,
+		Notifiers: []Notifier{notifier}}
+	target.Exec()
+	if len(notifier.notifications) != 0 {
+		t.Errorf("Exec muted want no notifications, got: %v", notifier.notifications)
+	}
+	target.Args = []string{"-c", "3", "not muted"}
+	target.Exec()
+	if len(notifier.notifications) != 1 {
+		t.Fatalf("Exec not muted want 1 notification, got: %v", notifier.notifications)
+	}
+	if n := notifier.notifications[0]; n.Cmd != "test/data/xecho" || n.ExitCode != 3 || n.Stdout != "not muted\n" || n.Host == "" {
+		t.Errorf("Exec not muted want the notification of the run, got: %+v", n)
+	}
+
+	target.Conf.Settings.MaxBufferBytes = 4
+	target.Exec()
+	if n := notifier.notifications[1]; n.Stdout != "[mute: truncated 6 bytes]\nted\n" {
+		t.Errorf("Exec not muted with max buffer want the last bytes of the output, got: %q", n.Stdout)
+	}
+}
+
+// testMutedNotifier records the notifications, including the muted runs
+type testMutedNotifier struct {
+	testNotifier
+}
+
+func (n *testMutedNotifier) NotifyMuted() bool {
+	return true
+}
+
+func TestExecNotifyMutedAndOnly(t *testing.T) {
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	notifier := new(testNotifier)
+	mutedNotifier := new(testMutedNotifier)
+	conf := DefaultConf()
+	conf.Settings.NotifyOnly = true
+	target := Target{Cmd: "test/data/xecho", Args: []string{"muted"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf,
+		Notifiers: []Notifier{notifier, mutedNotifier}}
+	target.Exec()
+	if len(notifier.notifications) != 0 || len(mutedNotifier.notifications) != 1 || !mutedNotifier.notifications[0].Muted {
+		t.Errorf("Exec muted want only the muted notifier notified, got: %v %v", notifier.notifications, mutedNotifier.notifications)
+	}
+	target.Args = []string{"-c", "3", "not muted"}
+	target.Exec()
+	if len(notifier.notifications) != 1 || len(mutedNotifier.notifications) != 2 {
+		t.Errorf("Exec not muted want all notifiers notified, got: %v %v", notifier.notifications, mutedNotifier.notifications)
+	}
+	if outBuf.Len() != 0 {
+		t.Errorf("Exec notify only want no output, got: %v", outBuf.String())
+	}
+	target.Notifiers = nil
+	target.Exec()
+	if outBuf.String() != "not muted\n" {
+		t.Errorf("Exec notify only without notifiers want the output, got: %v", outBuf.String())
+	}
+}
+
+func TestExecOutputFormatJSON(t *testing.T) {
+	var outBuf bytes.Buffer
+	var errBuf bytes.Buffer
+	conf := DefaultConf()
+	conf.Settings.OutputFormat = OutputFormatJSON
+	target := Target{Cmd: "test/data/xecho", Args: []string{"muted"}, Conf: conf, OutWriter: &outBuf, ErrWriter: &errBuf}
+	target.Exec()
+	if outBuf.Len() != 0 || errBuf.Len() != 0 {
+		t.Errorf("Exec json format muted want no output, got: %v %v", outBuf.String(), errBuf.String())
+	}
+	target.Cmd, target.Args = "sh", []string{"-c", "echo copied; echo failed >&2; exit 3"}
+	target.Exec()
+	if errBuf.Len() != 0 {
+		t.Errorf("Exec json format want no stderr, got: %v", errBuf.String())
+	}
+	var report RunReport
+	if err := json.Unmarshal(outBuf.Bytes(), &report); err != nil {
+		t.Fatalf("Exec json format want a JSON document, got: %v %v", outBuf.String(), err)
+	}
+	if report.Cmd != "sh" || report.ExitCode != 3 || report.Host == "" || report.Muted ||
+		report.Stdout != "copied\n" || report.Stderr != "failed\n" || report.Reason != "no criterion matched" {
+		t.Errorf("Exec json format want the report of the run, got: %+v", report)
+	}
+	if len(report.Criteria) != 1 || report.Criteria[0].Name != "default[0]" || report.Criteria[0].Matched {
+		t.Errorf("Exec json format want the evaluated criteria, got: %+v", report.Criteria)
+	}
+}
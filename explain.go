@@ -0,0 +1,104 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CriterionTrace is the evaluation of a Criterion against the results of a command
+type CriterionTrace struct {
+	Name      string     `json:"name"` // config name of the Criterion, like "default[0]" or "commands.backup[1]"
+	Criterion *Criterion `json:"-"`
+	Matched   bool       `json:"matched"`
+	Reason    string     `json:"reason,omitempty"` // why the Criterion did not match, empty if matched
+}
+
+// Explanation explains the mute decision for the results of a command, with the evaluation
+// of each Criterion of the selected criteria and never mute criteria
+type Explanation struct {
+	Cmd       string
+	Args      []string
+	Criteria  []CriterionTrace
+	NeverMute []CriterionTrace // evaluated only when a Criterion of the criteria matched
+	Muted     bool
+	Reason    string // why the output is muted or not
+}
+
+// Conf.Explain evaluates the criteria for the results of a command the same as Target.Run,
+// without running the command. The command, arguments, exit code, signal, output, timeout
+// and consecutive failures are read from the Result.
+// Comparing with the previous output (MuteIfUnchanged) never matches, since there is no state.
+func (c *Conf) Explain(r *Result) *Explanation {
+	ec := &execContext{ExitCode: r.ExitCode, Signal: r.Signal, TimedOut: r.TimedOut,
+		ConsecutiveFailures: r.ConsecutiveFailures, Stdout: newSpoolString(r.Stdout), Stderr: newSpoolString(r.Stderr)}
+	return c.explain(r.Cmd, r.Args, ec)
+}
+
+// Conf.explain evaluates the criteria of the command for the execContext, and returns the Explanation
+func (c *Conf) explain(cmd string, args []string, ec *execContext) *Explanation {
+	exp := &Explanation{Cmd: cmd, Args: args}
+	criteria, names := selectCriteria(cmd, args, c)
+	matched := traceCriteria(*criteria, names, ec, &exp.Criteria)
+	switch {
+	case ec.TimedOut:
+		exp.Reason = "timed out commands are never muted"
+	case matched == nil:
+		exp.Reason = "no criterion matched"
+	default:
+		neverMuted := traceCriteria(c.NeverMute, criteriaNames("never_mute", len(c.NeverMute)), ec, &exp.NeverMute)
+		exp.Muted = neverMuted == nil
+		if exp.Muted {
+			exp.Reason = fmt.Sprintf("%v matched", matched.Name)
+		} else {
+			exp.Reason = fmt.Sprintf("%v matched, but %v never mutes", matched.Name, neverMuted.Name)
+		}
+	}
+	return exp
+}
+
+// traceCriteria evaluates the criteria until the first match (unless ec is timed out),
+// and appends the traces. Returns the trace of the matched Criterion, or nil.
+func traceCriteria(criteria Criteria, names []string, ec *execContext, traces *[]CriterionTrace) *CriterionTrace {
+	if ec.TimedOut {
+		return nil
+	}
+	for i, crt := range criteria {
+		reason := evalCriterion(crt, ec)
+		*traces = append(*traces, CriterionTrace{Name: names[i], Criterion: crt, Matched: reason == "", Reason: reason})
+		if reason == "" {
+			return &(*traces)[len(*traces)-1]
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the explanation in a human readable format, implementing io.WriterTo
+func (e *Explanation) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "command: %v\n", strings.Join(append([]string{e.Cmd}, e.Args...), " "))
+	for _, section := range []struct {
+		title  string
+		traces []CriterionTrace
+	}{{"criteria", e.Criteria}, {"never mute", e.NeverMute}} {
+		if len(section.traces) > 0 {
+			fmt.Fprintf(&b, "%v:\n", section.title)
+		}
+		for _, trace := range section.traces {
+			if trace.Matched {
+				fmt.Fprintf(&b, "  %v: matched\n", trace.Name)
+			} else {
+				fmt.Fprintf(&b, "  %v: no match, %v\n", trace.Name, trace.Reason)
+			}
+		}
+	}
+	decision := "not muted"
+	if e.Muted {
+		decision = "muted"
+	}
+	fmt.Fprintf(&b, "decision: %v (%v)\n", decision, e.Reason)
+	return b.WriteTo(w)
+}
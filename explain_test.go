@@ -0,0 +1,75 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestConfExplain(t *testing.T) {
+	conf, err := ReadConfFile("test/data/simple.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile simple had error: %v", err)
+	}
+	conf.NeverMute.add(NewCriterion(nil, []string{"CRITICAL"}))
+
+	exp := conf.Explain(&Result{Cmd: "backup", ExitCode: 1, Stdout: "all OK"})
+	if !exp.Muted || len(exp.Criteria) != 2 || exp.Criteria[0].Matched || !exp.Criteria[1].Matched {
+		t.Errorf("Conf.Explain exit code 1 OK want muted by default[1], got: %+v", exp)
+	}
+	if exp.Criteria[0].Reason != "exit code 1 is not in [0]" {
+		t.Errorf("Conf.Explain exit code reason got: %q", exp.Criteria[0].Reason)
+	}
+	if len(exp.NeverMute) != 1 || exp.NeverMute[0].Matched {
+		t.Errorf("Conf.Explain want never mute evaluated without match, got: %+v", exp.NeverMute)
+	}
+
+	exp = conf.Explain(&Result{Cmd: "backup", ExitCode: 0, Stdout: "CRITICAL"})
+	if exp.Muted || exp.Reason != "default[0] matched, but never_mute[0] never mutes" {
+		t.Errorf("Conf.Explain never mute want not muted, got: %+v", exp)
+	}
+
+	exp = conf.Explain(&Result{Cmd: "backup", ExitCode: 2, Stdout: "failed"})
+	if exp.Muted || len(exp.NeverMute) != 0 || exp.Criteria[1].Reason != "stdout does not match any of patterns [OK]" {
+		t.Errorf("Conf.Explain no match want not muted, got: %+v", exp)
+	}
+
+	exp = conf.Explain(&Result{Cmd: "backup", ExitCode: 0, TimedOut: true})
+	if exp.Muted || len(exp.Criteria) != 0 {
+		t.Errorf("Conf.Explain timed out want not muted and not evaluated, got: %+v", exp)
+	}
+
+	var buf bytes.Buffer
+	_, _ = conf.Explain(&Result{Cmd: "backup", Args: []string{"--full"}, ExitCode: 1, Stdout: "OK"}).WriteTo(&buf)
+	want := "command: backup --full\ncriteria:\n  default[0]: no match, exit code 1 is not in [0]\n" +
+		"  default[1]: matched\nnever mute:\n" +
+		"  never_mute[0]: no match, stdout does not match any of patterns [CRITICAL]\n" +
+		"decision: muted (default[1] matched)\n"
+	if buf.String() != want {
+		t.Errorf("Explanation.WriteTo want:\n%v\ngot:\n%v", want, buf.String())
+	}
+}
+
+func TestConfExplainCriteriaNames(t *testing.T) {
+	conf, err := ReadConfFile("test/data/rules.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile rules had error: %v", err)
+	}
+	exp := conf.Explain(&Result{Cmd: "/usr/bin/rsync", ExitCode: 24})
+	if !exp.Muted || exp.Criteria[0].Name != "rules[1].criteria[0]" {
+		t.Errorf("Conf.Explain rule want muted by rules[1].criteria[0], got: %+v", exp)
+	}
+
+	conf.Settings.CommandsInheritDefault = true
+	exp = conf.Explain(&Result{Cmd: "backup", Signal: syscall.SIGTERM})
+	var names []string
+	for _, trace := range exp.Criteria {
+		names = append(names, trace.Name)
+	}
+	if strings.Join(names, ",") != "commands.backup[0],default[0]" {
+		t.Errorf("Conf.Explain inherited default want names commands.backup[0],default[0] got: %v", names)
+	}
+}
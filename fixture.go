@@ -0,0 +1,84 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Fixture is a recorded run of a command with its mute decision,
+// to replay against other configurations
+type Fixture struct {
+	Cmd                 string    `json:"cmd"`
+	Args                []string  `json:"args"`
+	ExitCode            int       `json:"exit_code"`
+	Signal              Signal    `json:"signal,omitzero"`
+	TimedOut            bool      `json:"timed_out,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures,omitempty"`
+	Stdout              string    `json:"stdout"`
+	Stderr              string    `json:"stderr"`
+	Muted               bool      `json:"muted"`
+	StartTime           time.Time `json:"start_time"`
+}
+
+// NewFixture returns a pointer to a Fixture recording the Result
+func NewFixture(r *Result) *Fixture {
+	return &Fixture{Cmd: r.Cmd, Args: r.Args, ExitCode: r.ExitCode, Signal: Signal(r.Signal), TimedOut: r.TimedOut,
+		ConsecutiveFailures: r.ConsecutiveFailures, Stdout: r.Stdout, Stderr: r.Stderr, Muted: r.Muted,
+		StartTime: r.StartTime}
+}
+
+// Result returns the recorded Result of the run, without the mute decision
+func (f *Fixture) Result() *Result {
+	return &Result{Cmd: f.Cmd, Args: f.Args, ExitCode: f.ExitCode, Signal: syscall.Signal(f.Signal),
+		TimedOut: f.TimedOut, ConsecutiveFailures: f.ConsecutiveFailures, Stdout: f.Stdout, Stderr: f.Stderr,
+		StartTime: f.StartTime}
+}
+
+// WriteFixture writes the Fixture as a JSON file in the directory, and returns the file path
+// The file is named after the command basename and the start time of the run.
+func WriteFixture(dir string, f *Fixture) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	content, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := unsafeFileChars.ReplaceAllString(filepath.Base(f.Cmd), "_")
+	pattern := fmt.Sprintf("%v-%v-*.json", name, f.StartTime.UTC().Format("20060102T150405Z"))
+	file, err := os.CreateTemp(dir, pattern) // unique name for the runs started at the same time
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err = file.Write(append(content, '\n')); err != nil {
+		return file.Name(), err
+	}
+	return file.Name(), file.Chmod(0o600)
+}
+
+// ReadFixture reads a Fixture from a JSON file
+func ReadFixture(path string) (*Fixture, error) {
+	fixture := new(Fixture)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fixture, err
+	}
+	if err = json.Unmarshal(content, fixture); err != nil {
+		return fixture, fmt.Errorf("invalid fixture %v: %w", path, err)
+	}
+	return fixture, nil
+}
+
+// Conf.Replay evaluates the recorded run against the Conf (see Conf.Explain),
+// and returns the Explanation and if the mute decision changed from the recorded one
+func (c *Conf) Replay(f *Fixture) (*Explanation, bool) {
+	exp := c.Explain(f.Result())
+	return exp, exp.Muted != f.Muted
+}
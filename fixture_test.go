@@ -0,0 +1,49 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWriteReadFixture(t *testing.T) {
+	dir := t.TempDir()
+	want := &Fixture{Cmd: "/usr/bin/backup", Args: []string{"--full"}, ExitCode: -1, Signal: Signal(syscall.SIGTERM),
+		Stdout: "done\n", Stderr: "WARN: slow\n", Muted: true, StartTime: time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)}
+	path, err := WriteFixture(dir, want)
+	if err != nil {
+		t.Fatalf("WriteFixture had error: %v", err)
+	}
+	if matched, _ := filepath.Match(filepath.Join(dir, "backup-20240501T020000Z-*.json"), path); !matched {
+		t.Errorf("WriteFixture path want backup-20240501T020000Z-*.json in %v got: %v", dir, path)
+	}
+	got, err := ReadFixture(path)
+	if err != nil {
+		t.Fatalf("ReadFixture had error: %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("ReadFixture want %+v got %+v", want, got)
+	}
+	if _, err = ReadFixture("test/data/simple.toml"); err == nil {
+		t.Errorf("ReadFixture invalid file want error, got nil")
+	}
+}
+
+func TestConfReplay(t *testing.T) {
+	conf, err := ReadConfFile("test/data/simple.toml")
+	if err != nil {
+		t.Fatalf("ReadConfFile simple had error: %v", err)
+	}
+	fixture := &Fixture{Cmd: "backup", ExitCode: 1, Stdout: "OK", Muted: false}
+	if exp, changed := conf.Replay(fixture); !changed || !exp.Muted {
+		t.Errorf("Conf.Replay want changed to muted, got changed: %v explanation: %+v", changed, exp)
+	}
+	fixture.Muted = true
+	if _, changed := conf.Replay(fixture); changed {
+		t.Errorf("Conf.Replay want unchanged, got changed")
+	}
+}
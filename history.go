@@ -0,0 +1,203 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultHistoryMaxBytes is the size of the history file to rotate it, when not configured
+const DefaultHistoryMaxBytes = 10 * 1024 * 1024
+
+// DefaultHistoryKeep is the number of rotated history files to keep, when not configured
+const DefaultHistoryKeep = 3
+
+// HistoryEntry is a record of a run in the history file
+type HistoryEntry struct {
+	StartTime           time.Time `json:"start_time"`
+	EndTime             time.Time `json:"end_time"`
+	DurationSeconds     float64   `json:"duration_seconds"`
+	Cmd                 string    `json:"cmd"`
+	Args                []string  `json:"args"`
+	ExitCode            int       `json:"exit_code"`
+	Signal              Signal    `json:"signal,omitzero"`
+	TimedOut            bool      `json:"timed_out,omitempty"`
+	Muted               bool      `json:"muted"`
+	Criterion           string    `json:"criterion,omitempty"`
+	NeverMuteCriterion  string    `json:"never_mute_criterion,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures,omitempty"`
+	StdoutBytes         int64     `json:"stdout_bytes"`
+	StderrBytes         int64     `json:"stderr_bytes"`
+}
+
+// NewHistoryEntry returns a pointer to a HistoryEntry recording the Result
+func NewHistoryEntry(r *Result) *HistoryEntry {
+	return &HistoryEntry{StartTime: r.StartTime, EndTime: r.StartTime.Add(r.Duration),
+		DurationSeconds: r.Duration.Seconds(), Cmd: r.Cmd, Args: r.Args, ExitCode: r.ExitCode,
+		Signal: Signal(r.Signal), TimedOut: r.TimedOut, Muted: r.Muted, Criterion: r.CriterionName,
+		NeverMuteCriterion: r.NeverMuteCriterionName, ConsecutiveFailures: r.ConsecutiveFailures,
+		StdoutBytes: r.StdoutBytes, StderrBytes: r.StderrBytes}
+}
+
+// HistoryFilter selects the history entries, zero values select all
+type HistoryFilter struct {
+	Command string    // command path or basename
+	Since   time.Time // started at or after
+	Unmuted bool      // only the runs that were not muted
+}
+
+// matches checks if the entry is selected by the filter
+func (f *HistoryFilter) matches(e *HistoryEntry) bool {
+	if f.Command != "" && f.Command != e.Cmd && f.Command != filepath.Base(e.Cmd) {
+		return false
+	}
+	if !f.Since.IsZero() && e.StartTime.Before(f.Since) {
+		return false
+	}
+	return !f.Unmuted || !e.Muted
+}
+
+// AppendHistory appends the entry as a JSON line to the history file, safe for concurrent runs.
+// The file is rotated (path.1, path.2, ...) before exceeding maxBytes, keeping up to keep rotated files.
+// Zero maxBytes means no rotation.
+func AppendHistory(path string, maxBytes int64, keep int, entry *HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	for range 3 { // retry if another run rotated the file meanwhile
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+		rotated, err := appendLocked(file, path, maxBytes, keep, line)
+		file.Close() // releases the lock
+		if !rotated {
+			return err
+		}
+	}
+	return fmt.Errorf("history file %v is rotated too often", path)
+}
+
+// appendLocked locks the opened history file and appends the line, or rotates the file if it's full.
+// Returns true if the file was rotated (by this or another run) and the line should be written to the new file.
+func appendLocked(file *os.File, path string, maxBytes int64, keep int, line []byte) (bool, error) {
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return false, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if pathInfo, err := os.Stat(path); err != nil || !os.SameFile(info, pathInfo) {
+		return true, nil
+	}
+	if maxBytes > 0 && info.Size() > 0 && info.Size()+int64(len(line)) > maxBytes {
+		return true, rotateFiles(path, keep)
+	}
+	_, err = file.Write(line)
+	return false, err
+}
+
+// rotateFiles renames the file to path.1, shifting the older rotated files up to keep, removing the oldest
+func rotateFiles(path string, keep int) error {
+	if keep < 1 {
+		return os.Remove(path)
+	}
+	for i := keep - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%v.%d", path, i), fmt.Sprintf("%v.%d", path, i+1)); err != nil &&
+			!os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
+
+// ReadHistory reads the history entries selected by the filter (nil selects all) from the history file
+// and its rotated files, oldest first. Missing files are skipped.
+func ReadHistory(path string, filter *HistoryFilter) ([]HistoryEntry, error) {
+	if filter == nil {
+		filter = new(HistoryFilter)
+	}
+	paths := []string{path}
+	for i := 1; ; i++ {
+		rotated := fmt.Sprintf("%v.%d", path, i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		paths = append([]string{rotated}, paths...)
+	}
+	var entries []HistoryEntry
+	for _, p := range paths {
+		file, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return entries, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for lineNum := 1; scanner.Scan(); lineNum++ {
+			var entry HistoryEntry
+			if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				file.Close()
+				return entries, fmt.Errorf("invalid history entry %v:%d: %w", p, lineNum, err)
+			}
+			if filter.matches(&entry) {
+				entries = append(entries, entry)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return entries, err
+		}
+	}
+	return entries, nil
+}
+
+// ParseSince parses the start of a time range, a time (RFC 3339 or date like 2006-01-02 in local time)
+// or a duration before now like "24h" or a number of seconds
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	d, err := ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want RFC 3339 time, date or duration", s)
+	}
+	return now.Add(-d), nil
+}
+
+// String returns a one line summary of the entry
+func (e *HistoryEntry) String() string {
+	status := fmt.Sprintf("exit %d", e.ExitCode)
+	if e.TimedOut {
+		status = "timed out"
+	} else if e.Signal != 0 {
+		status = fmt.Sprintf("signal %v", e.Signal)
+	}
+	decision := "not muted"
+	if e.Muted {
+		decision = fmt.Sprintf("muted by %v", e.Criterion)
+	} else if e.NeverMuteCriterion != "" {
+		decision = fmt.Sprintf("not muted by %v", e.NeverMuteCriterion)
+	}
+	return fmt.Sprintf("%v %8.2fs  %-12v %-24v %v", e.StartTime.Format(time.RFC3339), e.DurationSeconds, status,
+		decision, strings.Join(append([]string{e.Cmd}, e.Args...), " "))
+}
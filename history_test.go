@@ -0,0 +1,107 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppendReadHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	start := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)
+	entries := []*HistoryEntry{
+		{StartTime: start, Cmd: "/usr/bin/backup", ExitCode: 0, Muted: true, Criterion: "default[0]"},
+		{StartTime: start.Add(time.Hour), Cmd: "/usr/bin/backup", ExitCode: 1},
+		{StartTime: start.Add(2 * time.Hour), Cmd: "rsync", ExitCode: 24},
+	}
+	for _, entry := range entries {
+		if err := AppendHistory(path, 0, 0, entry); err != nil {
+			t.Fatalf("AppendHistory had error: %v", err)
+		}
+	}
+	got, err := ReadHistory(path, nil)
+	if err != nil {
+		t.Fatalf("ReadHistory had error: %v", err)
+	}
+	if len(got) != 3 || got[0].Criterion != "default[0]" || got[2].Cmd != "rsync" {
+		t.Errorf("ReadHistory want all 3 entries in order, got: %v", got)
+	}
+	for _, tc := range []struct {
+		filter HistoryFilter
+		want   int
+	}{
+		{HistoryFilter{Command: "backup"}, 2},
+		{HistoryFilter{Command: "/usr/bin/backup", Unmuted: true}, 1},
+		{HistoryFilter{Since: start.Add(90 * time.Minute)}, 1},
+		{HistoryFilter{Command: "cleanup"}, 0},
+	} {
+		if got, _ = ReadHistory(path, &tc.filter); len(got) != tc.want {
+			t.Errorf("ReadHistory filter %+v want %d entries got: %v", tc.filter, tc.want, got)
+		}
+	}
+	if got, err = ReadHistory(filepath.Join(t.TempDir(), "none.jsonl"), nil); err != nil || len(got) != 0 {
+		t.Errorf("ReadHistory missing file want no entries and no error, got: %v %v", got, err)
+	}
+}
+
+func TestAppendHistoryRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	entry := &HistoryEntry{Cmd: "backup", StartTime: time.Now()}
+	for range 10 {
+		if err := AppendHistory(path, 300, 2, entry); err != nil {
+			t.Fatalf("AppendHistory had error: %v", err)
+		}
+	}
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		if err != nil || info.Size() > 300 {
+			t.Errorf("AppendHistory rotated file %v want up to 300 bytes, got: %v %v", p, info, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("AppendHistory should keep only 2 rotated files, got %v.3", path)
+	}
+	got, _ := ReadHistory(path, nil)
+	if len(got) < 3 || len(got) >= 10 {
+		t.Errorf("ReadHistory rotated want the kept entries, got %d", len(got))
+	}
+}
+
+func TestAppendHistoryConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := AppendHistory(path, 0, 0, &HistoryEntry{Cmd: "backup", StartTime: time.Now()}); err != nil {
+				t.Errorf("AppendHistory had error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got, err := ReadHistory(path, nil); err != nil || len(got) != 20 {
+		t.Errorf("ReadHistory concurrent appends want 20 entries got: %d %v", len(got), err)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for s, want := range map[string]time.Time{
+		"2024-04-30T10:00:00Z": time.Date(2024, 4, 30, 10, 0, 0, 0, time.UTC),
+		"2024-04-30":           time.Date(2024, 4, 30, 0, 0, 0, 0, time.Local),
+		"24h":                  now.Add(-24 * time.Hour),
+		"60":                   now.Add(-time.Minute),
+	} {
+		if got, err := ParseSince(s, now); err != nil || !got.Equal(want) {
+			t.Errorf("ParseSince %q want %v got %v %v", s, want, got, err)
+		}
+	}
+	if _, err := ParseSince("yesterday", now); err == nil {
+		t.Errorf("ParseSince invalid want error, got nil")
+	}
+}
@@ -0,0 +1,89 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// output streams of a process
+const (
+	streamStdout = iota
+	streamStderr
+)
+
+// outputChunk is a record of consecutive bytes written to stdout or stderr of a process
+type outputChunk struct {
+	Seq    int
+	Stream int
+	Offset int64 // position of the chunk in the stream spool
+	Length int64
+	Time   time.Time // when the first bytes of the chunk were received
+}
+
+// chunkLog records the chunks written to stdout and stderr in the order they were received,
+// so the output can be replayed preserving the interleaving of the streams.
+// Consecutive writes to the same stream are merged into one chunk.
+type chunkLog struct {
+	mu     sync.Mutex
+	chunks []outputChunk
+}
+
+// chunkWriter writes to a stream spool, and records the written chunks in a chunkLog
+type chunkWriter struct {
+	stream int
+	spool  *spool
+	log    *chunkLog
+}
+
+// Write writes the bytes to the spool and records the chunk
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.log.mu.Lock()
+	defer w.log.mu.Unlock()
+	offset := w.spool.Len()
+	n, err := w.spool.Write(p)
+	if n > 0 {
+		w.log.record(w.stream, offset, int64(n))
+	}
+	return n, err
+}
+
+// record adds the chunk to the log, or merges it with the last chunk if it's from the same stream.
+// Caller should hold the lock.
+func (l *chunkLog) record(stream int, offset, length int64) {
+	if last := len(l.chunks) - 1; last >= 0 && l.chunks[last].Stream == stream {
+		l.chunks[last].Length += length
+		return
+	}
+	l.chunks = append(l.chunks, outputChunk{
+		Seq: len(l.chunks), Stream: stream, Offset: offset, Length: length, Time: time.Now()})
+}
+
+// Chunks returns a copy of the recorded chunks, in the order they were received
+func (l *chunkLog) Chunks() []outputChunk {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]outputChunk{}, l.chunks...)
+}
+
+// replay writes the chunks of stdout and stderr spools to the writers in the recorded order
+// When errWriter is nil, both streams are written to outWriter.
+func (l *chunkLog) replay(stdout, stderr *spool, outWriter, errWriter io.Writer) error {
+	if errWriter == nil {
+		errWriter = outWriter
+	}
+	var err error
+	for _, chunk := range l.Chunks() {
+		if chunk.Stream == streamStdout {
+			_, err = io.Copy(outWriter, stdout.section(chunk.Offset, chunk.Length))
+		} else {
+			_, err = io.Copy(errWriter, stderr.section(chunk.Offset, chunk.Length))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
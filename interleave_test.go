@@ -0,0 +1,66 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkLogReplay(t *testing.T) {
+	stdout := newSpool(0, false, 0)
+	stderr := newSpool(0, false, 0)
+	log := new(chunkLog)
+	outWriter := &chunkWriter{stream: streamStdout, spool: stdout, log: log}
+	errWriter := &chunkWriter{stream: streamStderr, spool: stderr, log: log}
+
+	outWriter.Write([]byte("out1\n"))
+	outWriter.Write([]byte("out2\n"))
+	errWriter.Write([]byte("err1\n"))
+	outWriter.Write([]byte("out3\n"))
+
+	chunks := log.Chunks()
+	if len(chunks) != 3 {
+		t.Fatalf("chunkLog should merge consecutive writes, want 3 chunks got %d", len(chunks))
+	}
+	if chunks[0].Length != 10 || chunks[2].Offset != 10 || chunks[2].Seq != 2 {
+		t.Errorf("chunkLog recorded unexpected chunks: %v", chunks)
+	}
+
+	var merged bytes.Buffer
+	if err := log.replay(stdout, stderr, &merged, nil); err != nil {
+		t.Errorf("chunkLog replay merged returned error: %v", err)
+	}
+	if got := merged.String(); got != "out1\nout2\nerr1\nout3\n" {
+		t.Errorf("chunkLog replay merged want interleaved output, got: %v", got)
+	}
+
+	var outBuf, errBuf bytes.Buffer
+	if err := log.replay(stdout, stderr, &outBuf, &errBuf); err != nil {
+		t.Errorf("chunkLog replay returned error: %v", err)
+	}
+	if outBuf.String() != "out1\nout2\nout3\n" || errBuf.String() != "err1\n" {
+		t.Errorf("chunkLog replay want separate streams, got: %v %v", outBuf.String(), errBuf.String())
+	}
+}
+
+func TestChunkLogReplayTruncated(t *testing.T) {
+	stdout := newSpool(8, true, 0)
+	stderr := newSpool(0, false, 0)
+	log := new(chunkLog)
+	outWriter := &chunkWriter{stream: streamStdout, spool: stdout, log: log}
+	errWriter := &chunkWriter{stream: streamStderr, spool: stderr, log: log}
+
+	outWriter.Write([]byte("head"))
+	errWriter.Write([]byte("e1"))
+	outWriter.Write([]byte("dropped"))
+	errWriter.Write([]byte("e2"))
+	outWriter.Write([]byte("tail"))
+
+	var merged bytes.Buffer
+	log.replay(stdout, stderr, &merged, nil)
+	want := "heade1\n[mute: truncated 7 bytes]\ne2tail"
+	if got := merged.String(); got != want {
+		t.Errorf("chunkLog replay truncated want '%v' got '%v'", want, got)
+	}
+}
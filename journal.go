@@ -0,0 +1,74 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultJournalAddress is the default unix datagram socket of the systemd journal native protocol
+const DefaultJournalAddress = "/run/systemd/journal/socket"
+
+// Journal is a Notifier logging each line of the output as a systemd journal entry with the MUTE_* fields,
+// using the journal native protocol over a unix datagram socket
+type Journal struct {
+	Address    string `toml:"address,omitempty"`    // see DefaultJournalAddress
+	Identifier string `toml:"identifier,omitempty"` // SYSLOG_IDENTIFIER, the command basename by default
+	Muted      bool   `toml:"muted,omitempty"`      // also log the muted runs
+}
+
+// NotifyMuted checks if the muted runs are logged
+func (j *Journal) NotifyMuted() bool {
+	return j.Muted
+}
+
+// Notify logs the output lines and the summary of the run to the journal
+func (j *Journal) Notify(ctx context.Context, n *Notification) error {
+	address := j.Address
+	if address == "" {
+		address = DefaultJournalAddress
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unixgram", address)
+	if err != nil {
+		return fmt.Errorf("journal %v: %w", address, err)
+	}
+	defer conn.Close()
+	for _, record := range n.logRecords() {
+		if _, err = conn.Write(j.entry(n, record)); err != nil {
+			return fmt.Errorf("journal %v: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// Journal.entry returns the journal entry of the record in the native protocol
+func (j *Journal) entry(n *Notification, record logRecord) []byte {
+	identifier := j.Identifier
+	if identifier == "" {
+		identifier = JobName(n.Cmd)
+	}
+	fields := append([][2]string{
+		{"MESSAGE", record.message},
+		{"PRIORITY", strconv.Itoa(record.severity)},
+		{"SYSLOG_IDENTIFIER", identifier},
+	}, n.logFields(record.stream)...)
+	var buf bytes.Buffer
+	for _, field := range fields {
+		if !strings.Contains(field[1], "\n") {
+			fmt.Fprintf(&buf, "%v=%v\n", field[0], field[1])
+			continue
+		}
+		// values with new lines are written as the name, the little endian 64 bit size and the value
+		buf.WriteString(field[0] + "\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(field[1])))
+		buf.WriteString(field[1] + "\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,37 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestJournalEntry(t *testing.T) {
+	n := &Notification{Cmd: "/usr/bin/backup", Args: []string{"a\nb"}, ExitCode: 3, Muted: true}
+	got := string((&Journal{}).entry(n, logRecord{stream: "stdout", message: "copied", severity: severityInfo}))
+	want := "MESSAGE=copied\nPRIORITY=6\nSYSLOG_IDENTIFIER=backup\n" +
+		"MUTE_COMMAND\n\x13\x00\x00\x00\x00\x00\x00\x00/usr/bin/backup a\nb\n" +
+		"MUTE_EXIT_CODE=3\nMUTE_MUTED=1\nMUTE_STREAM=stdout\n"
+	if got != want {
+		t.Errorf("Journal.entry want %q, got: %q", want, got)
+	}
+}
+
+func TestJournalNotify(t *testing.T) {
+	path, conn := listenTestUnixgram(t)
+	journal := Journal{Address: path, Identifier: "nightly"}
+	n := &Notification{Cmd: "backup", ExitCode: 0, Stderr: "warning\n"}
+	if err := journal.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Journal.Notify had error: %v", err)
+	}
+	entries := readTestDatagrams(t, conn, 2)
+	if !strings.HasPrefix(entries[0], "MESSAGE=warning\nPRIORITY=3\nSYSLOG_IDENTIFIER=nightly\n") ||
+		!strings.Contains(entries[0], "MUTE_STREAM=stderr\n") {
+		t.Errorf("Journal.Notify want stderr entry, got: %q", entries[0])
+	}
+	if !strings.HasPrefix(entries[1], "MESSAGE=backup exited with 0") || !strings.Contains(entries[1], "PRIORITY=4\n") {
+		t.Errorf("Journal.Notify want summary entry, got: %q", entries[1])
+	}
+}
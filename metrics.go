@@ -0,0 +1,79 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// metricsLabelEscaper escapes label values in Prometheus text format
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// JobName returns the default job name of a command in metrics, the command basename
+func JobName(cmd string) string {
+	return filepath.Base(cmd)
+}
+
+// FormatMetrics returns the metrics of the Result in Prometheus text format, labeled by the job name in the
+// mute_job label, to not clash with the job label Prometheus attaches to scraped series
+func FormatMetrics(job string, r *Result) string {
+	label := fmt.Sprintf(`{mute_job="%v"}`, metricsLabelEscaper.Replace(job))
+	boolValue := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	metrics := []struct {
+		name, help string
+		value      float64
+	}{
+		{"mute_job_last_exit_code", "Exit code of the last run of the job, -1 if terminated by a signal.", float64(r.ExitCode)},
+		{"mute_job_last_signal", "Signal number that terminated the last run of the job, 0 if it exited.", float64(r.Signal)},
+		{"mute_job_last_run_timestamp_seconds", "Unix time the last run of the job finished.",
+			float64(r.StartTime.Add(r.Duration).UnixMilli()) / 1000},
+		{"mute_job_duration_seconds", "Duration of the last run of the job in seconds.", r.Duration.Seconds()},
+		{"mute_job_timed_out", "Whether the last run of the job timed out.", boolValue(r.TimedOut)},
+		{"mute_job_muted", "Whether the output of the last run of the job was muted.", boolValue(r.Muted)},
+		{"mute_job_stdout_bytes", "Bytes the last run of the job wrote to stdout.", float64(r.StdoutBytes)},
+		{"mute_job_stderr_bytes", "Bytes the last run of the job wrote to stderr.", float64(r.StderrBytes)},
+	}
+	var b strings.Builder
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %v %v\n# TYPE %v gauge\n%v%v %v\n", m.name, m.help, m.name, m.name, label,
+			strconv.FormatFloat(m.value, 'f', -1, 64))
+	}
+	return b.String()
+}
+
+// WriteMetrics writes the metrics of the Result to the .prom file of the job in dir, replacing the
+// metrics of the previous run. The file is replaced atomically, so collectors never read a partial file.
+// Returns the path of the file.
+func WriteMetrics(dir, job string, r *Result) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, unsafeFileChars.ReplaceAllString(job, "_")+".prom")
+	// the temp file should not have the .prom suffix, to be ignored by collectors
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return path, err
+	}
+	defer os.Remove(file.Name()) // no op after rename
+	if _, err = file.WriteString(FormatMetrics(job, r)); err != nil {
+		file.Close()
+		return path, err
+	}
+	if err = file.Chmod(0o644); err != nil { // readable by the collector
+		file.Close()
+		return path, err
+	}
+	if err = file.Close(); err != nil {
+		return path, err
+	}
+	return path, os.Rename(file.Name(), path)
+}
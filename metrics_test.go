@@ -0,0 +1,56 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatMetrics(t *testing.T) {
+	result := &Result{Cmd: "/usr/bin/backup", ExitCode: 3, Muted: true, StdoutBytes: 12,
+		StartTime: time.Unix(1700000000, 0), Duration: 1500 * time.Millisecond}
+	metrics := FormatMetrics(`nightly "backup"`, result)
+	for _, want := range []string{
+		"# TYPE mute_job_last_exit_code gauge\n",
+		`mute_job_last_exit_code{mute_job="nightly \"backup\""} 3` + "\n",
+		`mute_job_last_run_timestamp_seconds{mute_job="nightly \"backup\""} 1700000001.5` + "\n",
+		`mute_job_duration_seconds{mute_job="nightly \"backup\""} 1.5` + "\n",
+		`mute_job_muted{mute_job="nightly \"backup\""} 1` + "\n",
+		`mute_job_timed_out{mute_job="nightly \"backup\""} 0` + "\n",
+		`mute_job_stdout_bytes{mute_job="nightly \"backup\""} 12` + "\n",
+		`mute_job_stderr_bytes{mute_job="nightly \"backup\""} 0` + "\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("FormatMetrics want %q, got: %v", want, metrics)
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "metrics")
+	path, err := WriteMetrics(dir, "backup", &Result{ExitCode: 1})
+	if err != nil {
+		t.Fatalf("WriteMetrics had error: %v", err)
+	}
+	if path != filepath.Join(dir, "backup.prom") {
+		t.Errorf("WriteMetrics want path %v/backup.prom, got: %v", dir, path)
+	}
+	if _, err = WriteMetrics(dir, "backup", &Result{ExitCode: 0}); err != nil {
+		t.Fatalf("WriteMetrics had error: %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	if !strings.Contains(string(content), `mute_job_last_exit_code{mute_job="backup"} 0`) {
+		t.Errorf("WriteMetrics want the last run metrics, got: %s", content)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("WriteMetrics want only the .prom file in dir, got: %v", entries)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o644 {
+		t.Errorf("WriteMetrics want file mode 0644, got: %v", info.Mode())
+	}
+}
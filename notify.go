@@ -0,0 +1,259 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification is the information of a run, sent by the notifiers
+type Notification struct {
+	Cmd             string    `json:"cmd"`
+	Args            []string  `json:"args"`
+	Host            string    `json:"host"`
+	ExitCode        int       `json:"exit_code"`
+	Signal          Signal    `json:"signal,omitzero"`
+	TimedOut        bool      `json:"timed_out"`
+	Muted           bool      `json:"muted"`
+	StartTime       time.Time `json:"start_time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// NeverMuteCriterion is the name of the never_mute criterion that prevented muting, if any
+	NeverMuteCriterion string `json:"never_mute_criterion,omitempty"`
+	Stdout             string `json:"stdout"`
+	Stderr             string `json:"stderr"`
+}
+
+// NewNotification returns a pointer to a Notification of the Result, with the captured output
+func NewNotification(r *Result, stdout, stderr string) *Notification {
+	host, _ := os.Hostname()
+	return &Notification{
+		Cmd:                r.Cmd,
+		Args:               r.Args,
+		Host:               host,
+		ExitCode:           r.ExitCode,
+		Signal:             Signal(r.Signal),
+		TimedOut:           r.TimedOut,
+		Muted:              r.Muted,
+		StartTime:          r.StartTime,
+		DurationSeconds:    r.Duration.Seconds(),
+		NeverMuteCriterion: r.NeverMuteCriterionName,
+		Stdout:             stdout,
+		Stderr:             stderr,
+	}
+}
+
+// Notification.status describes how the command ended, like "exited with 1"
+func (n *Notification) status() string {
+	if n.TimedOut {
+		return "timed out"
+	}
+	if n.Signal != 0 {
+		return fmt.Sprintf("terminated by %v", n.Signal)
+	}
+	return fmt.Sprintf("exited with %d", n.ExitCode)
+}
+
+// Notifier sends the Notification of an unmuted run, e.g. to a webhook or by email
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// MutedNotifier is a Notifier that is also notified of the muted runs, if NotifyMuted returns true
+type MutedNotifier interface {
+	Notifier
+	NotifyMuted() bool
+}
+
+// notifiesMuted checks if the notifier should be notified of the muted runs
+func notifiesMuted(notifier Notifier) bool {
+	mutedNotifier, ok := notifier.(MutedNotifier)
+	return ok && mutedNotifier.NotifyMuted()
+}
+
+// syslog severities used as the priority of the log records
+const (
+	severityErr     = 3
+	severityWarning = 4
+	severityNotice  = 5
+	severityInfo    = 6
+)
+
+// maxLogLineBytes is the size of the output lines logged as records, longer lines are truncated
+const maxLogLineBytes = 8192
+
+// logRecord is a record of a run logged by the log sinks, an output line or the summary
+type logRecord struct {
+	stream   string // stdout or stderr, empty for the summary
+	message  string
+	severity int
+}
+
+// Notification.logRecords returns a record for each line of stdout and stderr, and a summary record.
+// Lines of unmuted runs are notice (stdout) and err (stderr), and the summary is warning.
+// All records of muted runs are info.
+func (n *Notification) logRecords() []logRecord {
+	var records []logRecord
+	for _, stream := range []struct {
+		name, output string
+		severity     int
+	}{{"stdout", n.Stdout, severityNotice}, {"stderr", n.Stderr, severityErr}} {
+		if n.Muted {
+			stream.severity = severityInfo
+		}
+		for line := range strings.Lines(stream.output) {
+			line = strings.TrimRight(line, "\r\n")
+			if len(line) > maxLogLineBytes {
+				line = line[:maxLogLineBytes]
+			}
+			records = append(records, logRecord{stream: stream.name, message: line, severity: stream.severity})
+		}
+	}
+	decision, severity := "not muted", severityWarning
+	if n.Muted {
+		decision, severity = "muted", severityInfo
+	}
+	summary := fmt.Sprintf("%v %v after %.2fs, %v", StateKey(n.Cmd, n.Args), n.status(), n.DurationSeconds, decision)
+	return append(records, logRecord{message: summary, severity: severity})
+}
+
+// Notification.logFields returns the structured fields of the log records of the stream
+func (n *Notification) logFields(stream string) [][2]string {
+	fields := [][2]string{
+		{"MUTE_COMMAND", StateKey(n.Cmd, n.Args)},
+		{"MUTE_EXIT_CODE", strconv.Itoa(n.ExitCode)},
+		{"MUTE_MUTED", strconv.Itoa(boolInt(n.Muted))},
+	}
+	if stream != "" {
+		fields = append(fields, [2]string{"MUTE_STREAM", stream})
+	}
+	if n.Signal != 0 {
+		fields = append(fields, [2]string{"MUTE_SIGNAL", n.Signal.String()})
+	}
+	if n.TimedOut {
+		fields = append(fields, [2]string{"MUTE_TIMED_OUT", "1"})
+	}
+	return fields
+}
+
+// boolInt returns 1 for true, 0 for false
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Notify is the configuration of the notifiers of unmuted runs
+type Notify struct {
+	Webhooks []Webhook `toml:"webhook,omitempty"`
+	Emails   []Email   `toml:"email,omitempty"`
+	Syslogs  []Syslog  `toml:"syslog,omitempty"`
+	Journals []Journal `toml:"journal,omitempty"`
+}
+
+// Notify.validate checks the configuration of the notifiers
+func (n *Notify) validate() error {
+	for i := range n.Webhooks {
+		if err := n.Webhooks[i].validate(); err != nil {
+			return fmt.Errorf("invalid notify.webhook[%d]: %w", i, err)
+		}
+	}
+	for i := range n.Emails {
+		if err := n.Emails[i].validate(); err != nil {
+			return fmt.Errorf("invalid notify.email[%d]: %w", i, err)
+		}
+	}
+	for i := range n.Syslogs {
+		if err := n.Syslogs[i].validate(); err != nil {
+			return fmt.Errorf("invalid notify.syslog[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Notify.merge appends the notifiers of n2
+func (n *Notify) merge(n2 *Notify) *Notify {
+	n.Webhooks = append(n.Webhooks, n2.Webhooks...)
+	n.Emails = append(n.Emails, n2.Emails...)
+	n.Syslogs = append(n.Syslogs, n2.Syslogs...)
+	n.Journals = append(n.Journals, n2.Journals...)
+	return n
+}
+
+// Notify.equal checks if the notifiers have the same configuration
+func (n *Notify) equal(n2 *Notify) bool {
+	if len(n.Webhooks) != len(n2.Webhooks) || len(n.Emails) != len(n2.Emails) ||
+		len(n.Syslogs) != len(n2.Syslogs) || len(n.Journals) != len(n2.Journals) {
+		return false
+	}
+	for i := range n.Webhooks {
+		if !n.Webhooks[i].equal(&n2.Webhooks[i]) {
+			return false
+		}
+	}
+	for i := range n.Emails {
+		if !n.Emails[i].equal(&n2.Emails[i]) {
+			return false
+		}
+	}
+	for i := range n.Syslogs {
+		if n.Syslogs[i] != n2.Syslogs[i] {
+			return false
+		}
+	}
+	for i := range n.Journals {
+		if n.Journals[i] != n2.Journals[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Notify.redacted returns a copy of the notifiers with the secrets (passwords, webhook headers and URLs)
+// replaced by Redacted
+func (n *Notify) redacted() Notify {
+	r := *n
+	r.Webhooks = make([]Webhook, len(n.Webhooks))
+	for i, webhook := range n.Webhooks {
+		webhook.URL = redactURL(webhook.URL)
+		if len(webhook.Headers) > 0 {
+			headers := make(map[string]string, len(webhook.Headers))
+			for name := range webhook.Headers {
+				headers[name] = Redacted
+			}
+			webhook.Headers = headers
+		}
+		r.Webhooks[i] = webhook
+	}
+	r.Emails = make([]Email, len(n.Emails))
+	for i, email := range n.Emails {
+		if email.SMTPPassword != "" {
+			email.SMTPPassword = Redacted
+		}
+		r.Emails[i] = email
+	}
+	return r
+}
+
+// Notifiers returns the configured notifiers
+func (n *Notify) Notifiers() []Notifier {
+	var notifiers []Notifier
+	for i := range n.Webhooks {
+		notifiers = append(notifiers, &n.Webhooks[i])
+	}
+	for i := range n.Emails {
+		notifiers = append(notifiers, &n.Emails[i])
+	}
+	for i := range n.Syslogs {
+		notifiers = append(notifiers, &n.Syslogs[i])
+	}
+	for i := range n.Journals {
+		notifiers = append(notifiers, &n.Journals[i])
+	}
+	return notifiers
+}
@@ -0,0 +1,42 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// OutputFormatRaw writes the unmuted stdout/stderr as received from the command, the default output format
+const OutputFormatRaw = "raw"
+
+// OutputFormatJSON writes a RunReport of the unmuted run as a JSON document to stdout
+const OutputFormatJSON = "json"
+
+// RunReport is the JSON document of an unmuted run, with the output and the evaluated criteria
+type RunReport struct {
+	Notification
+	Criteria  []CriterionTrace `json:"criteria"`
+	NeverMute []CriterionTrace `json:"never_mute,omitempty"` // evaluated only when a Criterion matched
+	Reason    string           `json:"reason"`               // why the output is not muted
+}
+
+// NewRunReport returns a pointer to a RunReport of the Result with the captured output and the Explanation
+func NewRunReport(r *Result, stdout, stderr string, exp *Explanation) *RunReport {
+	report := &RunReport{Notification: *NewNotification(r, stdout, stderr), Criteria: exp.Criteria,
+		NeverMute: exp.NeverMute, Reason: exp.Reason}
+	if report.Criteria == nil {
+		report.Criteria = []CriterionTrace{}
+	}
+	return report
+}
+
+// WriteTo writes the RunReport as a JSON document in a line, implementing io.WriterTo
+func (r *RunReport) WriteTo(w io.Writer) (int64, error) {
+	content, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(content, '\n'))
+	return int64(n), err
+}
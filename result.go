@@ -0,0 +1,65 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"syscall"
+	"time"
+)
+
+// Result is the outcome of running a Target
+type Result struct {
+	Cmd      string
+	Args     []string
+	ExitCode int            // exit code of the command, -1 if terminated by a signal
+	Signal   syscall.Signal // signal that terminated the command, zero if it exited
+	TimedOut bool
+	Muted    bool
+	// Criterion is the first criterion that matched the run, nil if none matched
+	Criterion *Criterion
+	// NeverMuteCriterion is the first never_mute criterion that prevented muting, nil if none matched
+	NeverMuteCriterion *Criterion
+	// CriterionName and NeverMuteCriterionName are the config names of the matched criteria, like "default[0]"
+	CriterionName          string
+	NeverMuteCriterionName string
+	// ConsecutiveFailures is the number of failed runs in a row including this one, if state is used
+	ConsecutiveFailures int
+	// Recovered is true when this run succeeded and is muted after the previous unmuted failed run (with notify recovery)
+	Recovered bool
+	// OutputHash is the hash of the normalized output, if compared with the previous run
+	OutputHash  string
+	StartTime   time.Time
+	Duration    time.Duration
+	Stdout      string // captured stdout, with truncation marker if output was truncated
+	Stderr      string // captured stderr, with truncation marker if output was truncated
+	StdoutBytes int64  // total bytes the command wrote to stdout, including truncated ones
+	StderrBytes int64  // total bytes the command wrote to stderr, including truncated ones
+}
+
+// newResult returns a pointer to a Result populated from the execContext, excluding the captured text
+func newResult(cmd string, args []string, ec *execContext) *Result {
+	return &Result{
+		Cmd:         cmd,
+		Args:        args,
+		ExitCode:    ec.ExitCode,
+		Signal:      ec.Signal,
+		TimedOut:    ec.TimedOut,
+		StartTime:   ec.StartTime,
+		Duration:    ec.Duration,
+		StdoutBytes: ec.Stdout.Len(),
+		StderrBytes: ec.Stderr.Len(),
+	}
+}
+
+// Status returns the exit code mute should exit with for this Result,
+// which is the command exit code, or ExitErrTimeout if the command timed out,
+// or 128 + signal number if the command was terminated by a signal (shell convention)
+func (r *Result) Status() int {
+	if r.TimedOut {
+		return ExitErrTimeout
+	}
+	if r.Signal != 0 {
+		return 128 + int(r.Signal)
+	}
+	return r.ExitCode
+}
@@ -0,0 +1,112 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// signalNames maps the signal names to signals, to read signals from configuration
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":    syscall.SIGHUP,
+	"SIGINT":    syscall.SIGINT,
+	"SIGQUIT":   syscall.SIGQUIT,
+	"SIGILL":    syscall.SIGILL,
+	"SIGTRAP":   syscall.SIGTRAP,
+	"SIGABRT":   syscall.SIGABRT,
+	"SIGBUS":    syscall.SIGBUS,
+	"SIGFPE":    syscall.SIGFPE,
+	"SIGKILL":   syscall.SIGKILL,
+	"SIGUSR1":   syscall.SIGUSR1,
+	"SIGSEGV":   syscall.SIGSEGV,
+	"SIGUSR2":   syscall.SIGUSR2,
+	"SIGPIPE":   syscall.SIGPIPE,
+	"SIGALRM":   syscall.SIGALRM,
+	"SIGTERM":   syscall.SIGTERM,
+	"SIGCHLD":   syscall.SIGCHLD,
+	"SIGCONT":   syscall.SIGCONT,
+	"SIGSTOP":   syscall.SIGSTOP,
+	"SIGTSTP":   syscall.SIGTSTP,
+	"SIGTTIN":   syscall.SIGTTIN,
+	"SIGTTOU":   syscall.SIGTTOU,
+	"SIGURG":    syscall.SIGURG,
+	"SIGXCPU":   syscall.SIGXCPU,
+	"SIGXFSZ":   syscall.SIGXFSZ,
+	"SIGVTALRM": syscall.SIGVTALRM,
+	"SIGPROF":   syscall.SIGPROF,
+	"SIGWINCH":  syscall.SIGWINCH,
+	"SIGIO":     syscall.SIGIO,
+	"SIGSYS":    syscall.SIGSYS,
+}
+
+// forwardedSignals are the catchable signals that are forwarded to the running command.
+// Job control signals and SIGURG (used by Go runtime) are not forwarded.
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGALRM,
+	syscall.SIGWINCH,
+}
+
+// Signal is a signal that terminates a process, read from names like "SIGTERM", "TERM" or numbers
+type Signal syscall.Signal
+
+// UnmarshalText reads the signal from its name or number
+func (s *Signal) UnmarshalText(text []byte) error {
+	sig, err := ParseSignal(string(text))
+	*s = Signal(sig)
+	return err
+}
+
+// MarshalText writes the signal name
+func (s Signal) MarshalText() ([]byte, error) {
+	return []byte(SignalName(syscall.Signal(s))), nil
+}
+
+// String returns the signal name
+func (s Signal) String() string {
+	return SignalName(syscall.Signal(s))
+}
+
+// ParseSignal returns the signal from its name (with or without SIG prefix, case insensitive) or number
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if num, err := strconv.Atoi(name); err == nil && num > 0 {
+		return syscall.Signal(num), nil
+	}
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig, ok := signalNames[name]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("invalid signal %q", name)
+}
+
+// SignalName returns the name of the signal like "SIGTERM", or its number if the name is unknown
+func SignalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// signalsContain search for a given signal in a slice of signals
+func signalsContain(signals []Signal, sig syscall.Signal) bool {
+	for _, item := range signals {
+		if syscall.Signal(item) == sig {
+			return true
+		}
+	}
+	return false
+}
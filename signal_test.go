@@ -0,0 +1,36 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	cases := map[string]syscall.Signal{"SIGTERM": syscall.SIGTERM, "pipe": syscall.SIGPIPE, "9": syscall.SIGKILL}
+	for name, want := range cases {
+		got, err := ParseSignal(name)
+		if err != nil {
+			t.Errorf("ParseSignal %v want no error, got: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("ParseSignal %v want %v got %v", name, want, got)
+		}
+	}
+	if _, err := ParseSignal("SIGNOTHING"); err == nil {
+		t.Errorf("ParseSignal invalid name want error, got nil")
+	}
+	if _, err := ParseSignal("-1"); err == nil {
+		t.Errorf("ParseSignal negative number want error, got nil")
+	}
+}
+
+func TestSignalName(t *testing.T) {
+	if got := SignalName(syscall.SIGHUP); got != "SIGHUP" {
+		t.Errorf("SignalName want SIGHUP got %v", got)
+	}
+	if got := Signal(syscall.SIGINT).String(); got != "SIGINT" {
+		t.Errorf("Signal.String want SIGINT got %v", got)
+	}
+}
@@ -0,0 +1,258 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// spool stores the output of a process in memory up to a limit, and spills the rest
+// to a temporary file. In truncate mode, instead of spilling it keeps the head and the tail
+// of the output in memory and discards the middle.
+// A zero limit means no limit, and all the output is kept in memory.
+type spool struct {
+	mu       sync.Mutex
+	limit    int64
+	truncate bool
+	head     bytes.Buffer
+	tail     []byte // ring buffer of the last bytes, used in truncate mode
+	tailPos  int
+	tailLen  int
+	file     *os.File
+	fileSize int64
+	overflow bytes.Buffer // bytes after a failed write to the spill file, kept in memory
+	size     int64        // total bytes written
+}
+
+// spoolSegment is a part of the spool content, starting from a position of the whole output
+type spoolSegment struct {
+	start  int64
+	size   int64
+	data   io.ReaderAt
+	marker bool // marks truncated bytes, replaced by a marker line
+}
+
+// newSpool returns a pointer to a spool with the memory limit and pre allocated buffer
+func newSpool(limit int64, truncate bool, preAlloc int) *spool {
+	s := &spool{limit: limit, truncate: truncate}
+	if limit > 0 && int64(preAlloc) > limit {
+		preAlloc = int(limit)
+	}
+	if preAlloc > 0 {
+		s.head.Grow(preAlloc)
+	}
+	return s
+}
+
+// newSpoolString returns a pointer to an in memory spool with the string as its content
+func newSpoolString(content string) *spool {
+	s := newSpool(0, false, 0)
+	s.head.WriteString(content)
+	s.size = int64(len(content))
+	return s
+}
+
+// headLimit is the max bytes kept in the head buffer, zero means no limit
+func (s *spool) headLimit() int64 {
+	if s.truncate {
+		return s.limit - s.limit/2
+	}
+	return s.limit
+}
+
+// Write stores the bytes in memory, and in the spill file or tail buffer when memory limit is reached
+// If the spill file can not be created, the bytes are kept in memory.
+func (s *spool) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(p)
+	s.size += int64(n)
+	if s.limit <= 0 {
+		s.head.Write(p)
+		return n, nil
+	}
+	if room := s.headLimit() - int64(s.head.Len()); room > 0 {
+		if int64(len(p)) <= room {
+			s.head.Write(p)
+			return n, nil
+		}
+		s.head.Write(p[:room])
+		p = p[room:]
+	}
+	if s.truncate {
+		s.writeTail(p)
+		return n, nil
+	}
+	if s.overflow.Len() > 0 { // keep the order, spill file failed before
+		s.overflow.Write(p)
+		return n, nil
+	}
+	if s.file == nil {
+		file, err := os.CreateTemp("", "mute-*.spool")
+		if err != nil { // keep in memory, better use more memory than losing output
+			s.overflow.Write(p)
+			return n, nil
+		}
+		_ = os.Remove(file.Name()) // unlinked file is removed when closed
+		s.file = file
+	}
+	written, err := s.file.Write(p)
+	s.fileSize += int64(written)
+	if err != nil {
+		s.overflow.Write(p[written:])
+	}
+	return n, nil
+}
+
+// writeTail stores the bytes in the tail ring buffer, overwriting the oldest bytes
+func (s *spool) writeTail(p []byte) {
+	tailCap := int(s.limit / 2)
+	if tailCap < 1 {
+		return
+	}
+	if s.tail == nil {
+		s.tail = make([]byte, tailCap)
+	}
+	if len(p) >= tailCap {
+		copy(s.tail, p[len(p)-tailCap:])
+		s.tailPos = 0
+		s.tailLen = tailCap
+		return
+	}
+	n := copy(s.tail[s.tailPos:], p)
+	copy(s.tail, p[n:])
+	s.tailPos = (s.tailPos + len(p)) % tailCap
+	s.tailLen = min(s.tailLen+len(p), tailCap)
+}
+
+// tailBytes returns the bytes of the tail ring buffer in order
+func (s *spool) tailBytes() []byte {
+	if s.tailLen < len(s.tail) {
+		return s.tail[:s.tailLen]
+	}
+	return append(append([]byte{}, s.tail[s.tailPos:]...), s.tail[:s.tailPos]...)
+}
+
+// Len returns the total number of bytes written to the spool (including truncated ones)
+func (s *spool) Len() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Truncated returns the number of bytes discarded from the middle of the output
+func (s *spool) Truncated() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.truncated()
+}
+
+func (s *spool) truncated() int64 {
+	if !s.truncate {
+		return 0
+	}
+	return s.size - int64(s.head.Len()) - int64(s.tailLen)
+}
+
+// segments returns the parts of the spool content in order
+func (s *spool) segments() []spoolSegment {
+	headLen := int64(s.head.Len())
+	segments := []spoolSegment{{start: 0, size: headLen, data: bytes.NewReader(s.head.Bytes())}}
+	if s.file != nil {
+		segments = append(segments, spoolSegment{start: headLen, size: s.fileSize, data: s.file})
+	}
+	if s.overflow.Len() > 0 {
+		segments = append(segments, spoolSegment{
+			start: headLen + s.fileSize, size: int64(s.overflow.Len()), data: bytes.NewReader(s.overflow.Bytes())})
+	}
+	if dropped := s.truncated(); dropped > 0 {
+		segments = append(segments, spoolSegment{start: headLen, size: dropped, marker: true})
+	}
+	if s.tailLen > 0 {
+		segments = append(segments, spoolSegment{
+			start: s.size - int64(s.tailLen), size: int64(s.tailLen), data: bytes.NewReader(s.tailBytes())})
+	}
+	return segments
+}
+
+// section returns a reader of n bytes of the spool content from the offset
+// Truncated bytes are replaced with a marker line, if the section includes the start of the truncation.
+func (s *spool) section(off, n int64) io.Reader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var readers []io.Reader
+	end := off + n
+	for _, seg := range s.segments() {
+		from, to := max(off, seg.start), min(end, seg.start+seg.size)
+		if from >= to {
+			continue
+		}
+		if seg.marker {
+			if from == seg.start {
+				readers = append(readers, bytes.NewReader(truncateMarker(seg.size)))
+			}
+			continue
+		}
+		readers = append(readers, io.NewSectionReader(seg.data, from-seg.start, to-from))
+	}
+	return io.MultiReader(readers...)
+}
+
+// Reader returns a reader of the spool content, with a marker line in place of the truncated bytes
+func (s *spool) Reader() io.Reader {
+	return s.section(0, s.Len())
+}
+
+// WriteTo writes the spool content to the writer, implementing io.WriterTo
+func (s *spool) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, s.Reader())
+}
+
+// String returns the spool content as a string, reading spilled bytes into memory
+func (s *spool) String() string {
+	var b bytes.Buffer
+	_, _ = s.WriteTo(&b)
+	return b.String()
+}
+
+// Tail returns the last n bytes of the spool content as a string, with a marker line of the bytes before them,
+// reading only those bytes from the spill file. A non positive n returns the whole content.
+func (s *spool) Tail(n int64) string {
+	size := s.Len()
+	if n <= 0 || size <= n {
+		return s.String()
+	}
+	var b bytes.Buffer
+	b.Write(bytes.TrimPrefix(truncateMarker(size-n), []byte("\n")))
+	_, _ = io.Copy(&b, s.section(size-n, n))
+	return b.String()
+}
+
+// MatchRegexp checks if the spool content matches the regex, without reading it all into memory
+func (s *spool) MatchRegexp(re *regexp.Regexp) bool {
+	return re.MatchReader(bufio.NewReader(s.Reader()))
+}
+
+// Close releases the spill file. The spool should not be used afterwards.
+func (s *spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	s.fileSize = 0
+	return err
+}
+
+// truncateMarker returns the marker line that replaces the truncated bytes
+func truncateMarker(dropped int64) []byte {
+	return []byte(fmt.Sprintf("\n[mute: truncated %d bytes]\n", dropped))
+}
@@ -0,0 +1,105 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSpoolInMemory(t *testing.T) {
+	s := newSpool(0, false, 16)
+	defer s.Close()
+	s.Write([]byte("hello "))
+	s.Write([]byte("world"))
+	if got := s.String(); got != "hello world" {
+		t.Errorf("spool in memory String want 'hello world' got '%v'", got)
+	}
+	if s.Len() != 11 {
+		t.Errorf("spool in memory Len want 11 got %d", s.Len())
+	}
+	if s.file != nil {
+		t.Errorf("spool in memory should not spill to file")
+	}
+}
+
+func TestSpoolSpill(t *testing.T) {
+	s := newSpool(4, false, 1024)
+	defer s.Close()
+	s.Write([]byte("hello "))
+	s.Write([]byte("world"))
+	if s.file == nil {
+		t.Errorf("spool over limit should spill to file")
+	}
+	if s.head.Len() != 4 {
+		t.Errorf("spool over limit want 4 bytes in memory got %d", s.head.Len())
+	}
+	if got := s.String(); got != "hello world" {
+		t.Errorf("spool spill String want 'hello world' got '%v'", got)
+	}
+	if !s.MatchRegexp(regexp.MustCompile("o w")) {
+		t.Errorf("spool spill MatchRegexp want 'true' got 'false'")
+	}
+	if s.Truncated() != 0 {
+		t.Errorf("spool spill Truncated want 0 got %d", s.Truncated())
+	}
+}
+
+func TestSpoolTail(t *testing.T) {
+	s := newSpool(4, false, 0)
+	defer s.Close()
+	s.Write([]byte("hello "))
+	s.Write([]byte("world"))
+	if got := s.Tail(5); got != "[mute: truncated 6 bytes]\nworld" {
+		t.Errorf("spool Tail want the last bytes from the spill file with a marker got '%v'", got)
+	}
+	if got := s.Tail(0); got != "hello world" {
+		t.Errorf("spool Tail no limit want 'hello world' got '%v'", got)
+	}
+	if got := s.Tail(20); got != "hello world" {
+		t.Errorf("spool Tail more than content want 'hello world' got '%v'", got)
+	}
+}
+
+func TestSpoolSpillFailed(t *testing.T) {
+	t.Setenv("TMPDIR", "/nonexistent/mute-test")
+	s := newSpool(4, false, 0)
+	defer s.Close()
+	s.Write([]byte("hello "))
+	s.Write([]byte("world"))
+	if got := s.String(); got != "hello world" {
+		t.Errorf("spool spill failed String want 'hello world' in order got '%v'", got)
+	}
+	if s.file != nil || s.overflow.Len() != 7 {
+		t.Errorf("spool spill failed want the bytes after the limit in memory, got %d", s.overflow.Len())
+	}
+}
+
+func TestSpoolTruncate(t *testing.T) {
+	s := newSpool(8, true, 0)
+	defer s.Close()
+	s.Write([]byte("head"))
+	s.Write([]byte(strings.Repeat("-", 100)))
+	s.Write([]byte("ta"))
+	s.Write([]byte("il"))
+	if s.file != nil {
+		t.Errorf("spool truncate should not spill to file")
+	}
+	if s.Len() != 108 {
+		t.Errorf("spool truncate Len want 108 got %d", s.Len())
+	}
+	if s.Truncated() != 100 {
+		t.Errorf("spool truncate Truncated want 100 got %d", s.Truncated())
+	}
+	want := "head\n[mute: truncated 100 bytes]\ntail"
+	if got := s.String(); got != want {
+		t.Errorf("spool truncate String want '%v' got '%v'", want, got)
+	}
+
+	s = newSpool(8, true, 0)
+	s.Write([]byte("abc"))
+	if got := s.String(); got != "abc" {
+		t.Errorf("spool truncate under limit String want 'abc' got '%v'", got)
+	}
+}
@@ -0,0 +1,203 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RunState is the persisted state of the previous runs of a command
+type RunState struct {
+	Key                 string    `json:"key"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	FirstFailure        time.Time `json:"first_failure,omitzero"` // when the consecutive failures started
+	LastRun             time.Time `json:"last_run,omitzero"`
+	LastExitCode        int       `json:"last_exit_code"`
+	LastMuted           bool      `json:"last_muted"`
+	LastAlert           time.Time `json:"last_alert,omitzero"` // when the output was last printed
+	OutputHash          string    `json:"output_hash,omitempty"`
+}
+
+// StateStore persists RunState of commands in a directory, one file per command key.
+// Files are replaced atomically, and locked (by a lock file next to them) while updated,
+// so concurrent runs of the same command are safe.
+type StateStore struct {
+	Dir string
+}
+
+// ErrInvalidState is the error of a state file that could not be decoded, the state is reset when updated
+var ErrInvalidState = errors.New("invalid state file")
+
+// unsafeFileChars matches the chars not used in state file names
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// DefaultStateDir returns the directory to store the state when not configured:
+// $XDG_STATE_HOME/mute, or ~/.local/state/mute, or mute-UID in the temp dir
+func DefaultStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "mute")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "mute")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("mute-%d", os.Getuid()))
+}
+
+// NewStateStore returns a pointer to a StateStore in the directory, or the default directory if empty
+func NewStateStore(dir string) *StateStore {
+	if dir == "" {
+		dir = DefaultStateDir()
+	}
+	return &StateStore{Dir: dir}
+}
+
+// StateKey returns the default state key of a command and its arguments
+func StateKey(cmd string, args []string) string {
+	return strings.Join(append([]string{cmd}, args...), " ")
+}
+
+// path returns the state file path of the key, a readable name and hash of the key
+func (s *StateStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := unsafeFileChars.ReplaceAllString(filepath.Base(strings.Fields(key + " _")[0]), "_")
+	return filepath.Join(s.Dir, fmt.Sprintf("%s-%s.json", name, hex.EncodeToString(sum[:8])))
+}
+
+// Load returns the RunState of the key, an empty RunState if there is no state yet
+// or the state file is invalid (with an ErrInvalidState error)
+func (s *StateStore) Load(key string) (*RunState, error) {
+	path := s.path(key)
+	state := &RunState{Key: key}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if len(content) > 0 {
+		if err = json.Unmarshal(content, state); err != nil {
+			return &RunState{Key: key}, fmt.Errorf("%w %v: %w", ErrInvalidState, path, err)
+		}
+	}
+	return state, nil
+}
+
+// Update locks the state file of the key, and calls fn with the current RunState
+// to modify it. The modified RunState is saved unless fn returns an error.
+// An invalid state file is treated as empty, and replaced by the saved state, returning the
+// ErrInvalidState error after saving to warn about it.
+func (s *StateStore) Update(key string, fn func(*RunState) error) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	path := s.path(key)
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close() // releases the lock
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+
+	state, loadErr := s.Load(key)
+	if loadErr != nil && !errors.Is(loadErr, ErrInvalidState) {
+		return loadErr
+	}
+	if err = fn(state); err != nil {
+		return err
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(path, content); err != nil {
+		return err
+	}
+	return loadErr
+}
+
+// writeFileAtomic writes the content to a temp file in the same directory, and renames it to the path,
+// so the file has either the old or the new content if writing fails (e.g. crash or a full disk)
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no op after renamed
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// record updates the RunState with the results of a run, resetting failures on success
+func (st *RunState) record(ec *execContext, muted bool) {
+	if ec.failed() {
+		if st.ConsecutiveFailures == 0 {
+			st.FirstFailure = ec.StartTime
+		}
+		st.ConsecutiveFailures++
+	} else {
+		st.ConsecutiveFailures = 0
+		st.FirstFailure = time.Time{}
+	}
+	st.LastRun = ec.StartTime
+	st.LastExitCode = ec.ExitCode
+	st.LastMuted = muted
+	if !muted {
+		st.LastAlert = ec.StartTime
+	}
+	st.OutputHash = ec.OutputHash
+}
+
+// outputHash returns the hex encoded hash of stdout and stderr, after applying the substitutions on each line
+func outputHash(subs []Substitution, outputs ...*spool) (string, error) {
+	hash := sha256.New()
+	for i, output := range outputs {
+		if i > 0 {
+			hash.Write([]byte{0}) // separate the streams, so moving bytes between them changes the hash
+		}
+		if len(subs) == 0 {
+			if _, err := output.WriteTo(hash); err != nil {
+				return "", err
+			}
+			continue
+		}
+		reader := bufio.NewReader(output.Reader())
+		for {
+			line, err := reader.ReadBytes('\n')
+			for _, sub := range subs {
+				line = sub.Pattern.Regexp.ReplaceAll(line, []byte(sub.Replace))
+			}
+			hash.Write(line)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
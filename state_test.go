@@ -0,0 +1,124 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStateStoreUpdateLoad(t *testing.T) {
+	store := NewStateStore(t.TempDir())
+	state, err := store.Load("backup --all")
+	if err != nil {
+		t.Fatalf("StateStore.Load missing state had error: %v", err)
+	}
+	if state.ConsecutiveFailures != 0 || state.Key != "backup --all" {
+		t.Errorf("StateStore.Load missing state want empty got: %v", state)
+	}
+	err = store.Update("backup --all", func(st *RunState) error {
+		st.record(testExecContext(2, nil, nil), false)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StateStore.Update had error: %v", err)
+	}
+	state, _ = store.Load("backup --all")
+	if state.ConsecutiveFailures != 1 || state.LastExitCode != 2 {
+		t.Errorf("StateStore.Load after failure want 1 failure, exit code 2 got: %v", state)
+	}
+	other, _ := store.Load("backup --home")
+	if other.ConsecutiveFailures != 0 {
+		t.Errorf("StateStore.Load other key want 0 failures got: %v", other.ConsecutiveFailures)
+	}
+}
+
+func TestStateStoreInvalidState(t *testing.T) {
+	store := NewStateStore(t.TempDir())
+	if err := os.WriteFile(store.path("backup"), []byte(`{"key": "backup", "consecu`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load("backup"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("StateStore.Load invalid state want ErrInvalidState got: %v", err)
+	}
+	update := func(st *RunState) error {
+		st.record(testExecContext(1, nil, nil), false)
+		return nil
+	}
+	if err := store.Update("backup", update); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("StateStore.Update invalid state want ErrInvalidState got: %v", err)
+	}
+	if err := store.Update("backup", update); err != nil {
+		t.Errorf("StateStore.Update after reset want no error got: %v", err)
+	}
+	if state, err := store.Load("backup"); err != nil || state.ConsecutiveFailures != 2 {
+		t.Errorf("StateStore.Load after reset want 2 failures got: %v %v", state, err)
+	}
+	if tmpFiles, _ := filepath.Glob(filepath.Join(store.Dir, "*.tmp")); len(tmpFiles) != 0 {
+		t.Errorf("StateStore.Update should not leave temp files, got: %v", tmpFiles)
+	}
+}
+
+func TestStateStoreConcurrentUpdate(t *testing.T) {
+	store := NewStateStore(t.TempDir())
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.Update("flaky", func(st *RunState) error {
+				st.record(testExecContext(1, nil, nil), false)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("StateStore.Update had error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	state, _ := store.Load("flaky")
+	if state.ConsecutiveFailures != 20 {
+		t.Errorf("StateStore concurrent updates want 20 failures got: %d", state.ConsecutiveFailures)
+	}
+}
+
+func TestRunStateRecordResets(t *testing.T) {
+	state := RunState{}
+	ec := testExecContext(1, nil, nil)
+	ec.StartTime = time.Now()
+	state.record(ec, true)
+	state.record(ec, true)
+	if state.ConsecutiveFailures != 2 || state.FirstFailure.IsZero() {
+		t.Errorf("RunState.record failures want 2 got: %d", state.ConsecutiveFailures)
+	}
+	state.record(testExecContext(0, nil, nil), true)
+	if state.ConsecutiveFailures != 0 || !state.FirstFailure.IsZero() {
+		t.Errorf("RunState.record success want reset got: %v", state)
+	}
+}
+
+func TestOutputHash(t *testing.T) {
+	subs := []Substitution{{Pattern: NewStdoutPattern(`\d{2}:\d{2}:\d{2}`), Replace: "TIME"}}
+	hash1, _ := outputHash(subs, newSpoolString("10:00:01 disk 91% full\n"), newSpoolString(""))
+	hash2, _ := outputHash(subs, newSpoolString("11:30:00 disk 91% full\n"), newSpoolString(""))
+	if hash1 == "" || hash1 != hash2 {
+		t.Errorf("outputHash normalized output want same hash got: %q %q", hash1, hash2)
+	}
+	hash3, _ := outputHash(subs, newSpoolString("11:30:00 disk 95% full\n"), newSpoolString(""))
+	if hash1 == hash3 {
+		t.Errorf("outputHash changed output want different hash got: %q", hash3)
+	}
+	hash4, _ := outputHash(subs, newSpoolString(""), newSpoolString("10:00:01 disk 91% full\n"))
+	if hash1 == hash4 {
+		t.Errorf("outputHash output on another stream want different hash got: %q", hash4)
+	}
+	hash5, _ := outputHash(nil, newSpoolString("10:00:01 disk 91% full\n"), newSpoolString(""))
+	hash6, _ := outputHash(nil, newSpoolString("11:30:00 disk 91% full\n"), newSpoolString(""))
+	if hash5 == hash6 {
+		t.Errorf("outputHash without substitutions want different hash got: %q", hash5)
+	}
+}
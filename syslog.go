@@ -0,0 +1,106 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultSyslogAddress is the default unix datagram socket of the syslog daemon
+const DefaultSyslogAddress = "/dev/log"
+
+// syslogFacilities are the syslog facility codes by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7, "uucp": 8,
+	"cron": 9, "authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSDEscaper escapes structured data param values of RFC 5424
+var syslogSDEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// Syslog is a Notifier logging each line of the output as a syslog record to a unix datagram socket.
+// Records are in RFC 3164 format by default, as the local socket daemons (journald, rsyslog imuxsock)
+// parse them so. In RFC 5424 format the records have the MUTE_* structured fields, for daemons parsing it.
+type Syslog struct {
+	Address  string `toml:"address,omitempty"`  // see DefaultSyslogAddress
+	Facility string `toml:"facility,omitempty"` // user by default
+	Tag      string `toml:"tag,omitempty"`      // the command basename by default
+	Format   string `toml:"format,omitempty"`   // rfc3164 (default) or rfc5424
+	Muted    bool   `toml:"muted,omitempty"`    // also log the muted runs
+}
+
+// Syslog.validate checks the facility and the format
+func (s *Syslog) validate() error {
+	if _, ok := syslogFacilities[s.Facility]; s.Facility != "" && !ok {
+		return fmt.Errorf("unknown facility %q", s.Facility)
+	}
+	if s.Format != "" && s.Format != "rfc5424" && s.Format != "rfc3164" {
+		return fmt.Errorf("unknown format %q, should be rfc3164 or rfc5424", s.Format)
+	}
+	return nil
+}
+
+// NotifyMuted checks if the muted runs are logged
+func (s *Syslog) NotifyMuted() bool {
+	return s.Muted
+}
+
+// Notify logs the output lines and the summary of the run to syslog
+func (s *Syslog) Notify(ctx context.Context, n *Notification) error {
+	address := s.Address
+	if address == "" {
+		address = DefaultSyslogAddress
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unixgram", address)
+	if err != nil {
+		return fmt.Errorf("syslog %v: %w", address, err)
+	}
+	defer conn.Close()
+	for _, record := range n.logRecords() {
+		if _, err = conn.Write(s.format(n, record, time.Now())); err != nil {
+			return fmt.Errorf("syslog %v: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// Syslog.format returns the syslog message of the record
+func (s *Syslog) format(n *Notification, record logRecord, now time.Time) []byte {
+	facility, ok := syslogFacilities[s.Facility]
+	if !ok {
+		facility = syslogFacilities["user"]
+	}
+	tag := s.Tag
+	if tag == "" {
+		tag = JobName(n.Cmd)
+	}
+	tag = strings.Map(func(r rune) rune { // printable ASCII without spaces
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, tag)
+	priority := facility*8 + record.severity
+	if s.Format != "rfc5424" {
+		return fmt.Appendf(nil, "<%d>%v %v[%d]: %v", priority, now.Format(time.Stamp), tag, os.Getpid(), record.message)
+	}
+	host := n.Host
+	if host == "" {
+		host = "-"
+	}
+	// 32473 is the example private enterprise number (RFC 5612), as mute has no registered number
+	sd := "[mute@32473"
+	for _, field := range n.logFields(record.stream) {
+		sd += fmt.Sprintf(` %v="%v"`, field[0], syslogSDEscaper.Replace(field[1]))
+	}
+	sd += "]"
+	return fmt.Appendf(nil, "<%d>1 %v %v %.48v %d - %v %v", priority, now.Format(time.RFC3339Nano), host, tag,
+		os.Getpid(), sd, record.message)
+}
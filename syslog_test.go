@@ -0,0 +1,118 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenTestUnixgram listens on a unix datagram socket in a temp dir, and returns the socket path and the connection
+func listenTestUnixgram(t *testing.T) (string, *net.UnixConn) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return path, conn
+}
+
+// readTestDatagrams reads n datagrams from the connection
+func readTestDatagrams(t *testing.T, conn *net.UnixConn, n int) []string {
+	t.Helper()
+	var datagrams []string
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for range n {
+		size, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read datagram had error: %v", err)
+		}
+		datagrams = append(datagrams, string(buf[:size]))
+	}
+	return datagrams
+}
+
+func TestNotificationLogRecords(t *testing.T) {
+	n := &Notification{Cmd: "backup", ExitCode: 3, Stdout: "copied\nskipped\n", Stderr: "failed"}
+	records := n.logRecords()
+	want := []logRecord{
+		{"stdout", "copied", severityNotice},
+		{"stdout", "skipped", severityNotice},
+		{"stderr", "failed", severityErr},
+		{"", "backup exited with 3 after 0.00s, not muted", severityWarning},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(want) {
+		t.Errorf("Notification.logRecords want %v, got: %v", want, records)
+	}
+	n.Muted = true
+	for _, record := range n.logRecords() {
+		if record.severity != severityInfo {
+			t.Errorf("Notification.logRecords muted want info severity, got: %v", record)
+		}
+	}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	n := &Notification{Cmd: "/usr/bin/backup", Args: []string{"--full"}, Host: "db1", ExitCode: 3}
+	now := time.Date(2024, 5, 1, 2, 3, 4, 0, time.UTC)
+	record := logRecord{stream: "stderr", message: `disk "full"`, severity: severityErr}
+	got := string((&Syslog{Facility: "cron", Format: "rfc5424"}).format(n, record, now))
+	want := fmt.Sprintf(`<75>1 2024-05-01T02:03:04Z db1 backup %d - [mute@32473 MUTE_COMMAND="/usr/bin/backup --full" `+
+		`MUTE_EXIT_CODE="3" MUTE_MUTED="0" MUTE_STREAM="stderr"] disk "full"`, os.Getpid())
+	if got != want {
+		t.Errorf("Syslog.format rfc5424 want %v, got: %v", want, got)
+	}
+	got = string((&Syslog{Tag: "nightly backup", Format: "rfc3164"}).format(n, record, now))
+	want = fmt.Sprintf(`<11>May  1 02:03:04 nightly_backup[%d]: disk "full"`, os.Getpid())
+	if got != want {
+		t.Errorf("Syslog.format rfc3164 want %v, got: %v", want, got)
+	}
+	got = string((&Syslog{}).format(n, record, now))
+	want = fmt.Sprintf(`<11>May  1 02:03:04 backup[%d]: disk "full"`, os.Getpid())
+	if got != want {
+		t.Errorf("Syslog.format default want rfc3164 %v, got: %v", want, got)
+	}
+}
+
+func TestSyslogNotify(t *testing.T) {
+	path, conn := listenTestUnixgram(t)
+	syslog := Syslog{Address: path, Format: "rfc5424"}
+	n := &Notification{Cmd: "backup", ExitCode: 1, Stdout: "copied\n", Stderr: "failed\n"}
+	if err := syslog.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Syslog.Notify had error: %v", err)
+	}
+	records := readTestDatagrams(t, conn, 3)
+	if !strings.HasPrefix(records[0], "<13>1 ") || !strings.HasSuffix(records[0], `MUTE_STREAM="stdout"] copied`) {
+		t.Errorf("Syslog.Notify want stdout record with notice priority, got: %v", records[0])
+	}
+	if !strings.HasPrefix(records[1], "<11>1 ") || !strings.HasSuffix(records[1], `MUTE_STREAM="stderr"] failed`) {
+		t.Errorf("Syslog.Notify want stderr record with err priority, got: %v", records[1])
+	}
+	if !strings.HasPrefix(records[2], "<12>1 ") || !strings.Contains(records[2], "backup exited with 1") {
+		t.Errorf("Syslog.Notify want summary record with warning priority, got: %v", records[2])
+	}
+	if err := (&Syslog{Address: filepath.Join(t.TempDir(), "none")}).Notify(context.Background(), n); err == nil {
+		t.Errorf("Syslog.Notify missing socket want error, got nil")
+	}
+}
+
+func TestSyslogValidate(t *testing.T) {
+	if err := (&Syslog{Facility: "local9"}).validate(); err == nil {
+		t.Errorf("Syslog.validate unknown facility want error, got nil")
+	}
+	if err := (&Syslog{Format: "json"}).validate(); err == nil {
+		t.Errorf("Syslog.validate unknown format want error, got nil")
+	}
+	if err := (&Syslog{Facility: "local0", Format: "rfc3164"}).validate(); err != nil {
+		t.Errorf("Syslog.validate want no error, got: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+// Package mute implements functions to execute other programs muting std streams if required
+// license: MIT, see LICENSE for details.
+package mute
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultWebhookTimeout is the default timeout of each webhook request
+const DefaultWebhookTimeout = 10 * time.Second
+
+// DefaultWebhookRetries is the default number of retries of failed webhook requests
+const DefaultWebhookRetries = 2
+
+// DefaultWebhookRetryBackoff is the default wait before the first retry, doubled for each next retry
+const DefaultWebhookRetryBackoff = time.Second
+
+// DefaultWebhookMaxOutputBytes is the default number of the last bytes of stdout/stderr sent to webhooks
+const DefaultWebhookMaxOutputBytes = 4096
+
+// Webhook is a Notifier sending HTTP requests, with the Notification as JSON body or rendered by BodyTemplate
+type Webhook struct {
+	URL    string `toml:"url"`
+	Method string `toml:"method,omitempty"` // POST by default
+	// content type is application/json unless set in headers
+	Headers map[string]string `toml:"headers,omitempty"`
+	// text/template of the body with the Notification as data, and a json function to quote values
+	BodyTemplate string `toml:"body_template,omitempty"`
+	// timeout of each request, see DefaultWebhookTimeout
+	Timeout Duration `toml:"timeout,omitzero"`
+	// retries of requests failed with errors or 5xx/429 responses, see DefaultWebhookRetries. negative for no retry
+	Retries int `toml:"retries,omitzero"`
+	// wait before the first retry, doubled for each next retry. see DefaultWebhookRetryBackoff
+	RetryBackoff Duration `toml:"retry_backoff,omitzero"`
+	// send only the last bytes of stdout/stderr, see DefaultWebhookMaxOutputBytes. negative for no limit
+	MaxOutputBytes int `toml:"max_output_bytes,omitzero"`
+	// Client sends the requests, http.DefaultClient if nil
+	Client *http.Client `toml:"-"`
+}
+
+// webhookFuncs are the functions available in the webhook body template
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Webhook.validate checks the URL and the body template
+func (w *Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return redactURLError(err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url %q should be http or https", redactURL(w.URL))
+	}
+	_, err = w.template()
+	return err
+}
+
+// Webhook.equal checks if the Webhooks have the same configuration
+func (w *Webhook) equal(w2 *Webhook) bool {
+	if len(w.Headers) != len(w2.Headers) {
+		return false
+	}
+	for name, value := range w.Headers {
+		if value2, ok := w2.Headers[name]; !ok || value != value2 {
+			return false
+		}
+	}
+	return w.URL == w2.URL && w.Method == w2.Method && w.BodyTemplate == w2.BodyTemplate &&
+		w.Timeout == w2.Timeout && w.Retries == w2.Retries && w.RetryBackoff == w2.RetryBackoff &&
+		w.MaxOutputBytes == w2.MaxOutputBytes
+}
+
+// Webhook.template returns the parsed body template, nil if not set
+func (w *Webhook) template() (*template.Template, error) {
+	if w.BodyTemplate == "" {
+		return nil, nil
+	}
+	return template.New("body").Funcs(webhookFuncs).Option("missingkey=error").Parse(w.BodyTemplate)
+}
+
+// Webhook.body returns the request body of the Notification, with the output truncated
+func (w *Webhook) body(n *Notification) ([]byte, error) {
+	maxBytes := w.MaxOutputBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultWebhookMaxOutputBytes
+	}
+	truncated := *n
+	truncated.Stdout, truncated.Stderr = tailOutput(n.Stdout, maxBytes), tailOutput(n.Stderr, maxBytes)
+	tmpl, err := w.template()
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == nil {
+		return json.Marshal(&truncated)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, &truncated)
+	return buf.Bytes(), err
+}
+
+// Notify sends the Notification to the webhook, retrying failed requests with backoff
+func (w *Webhook) Notify(ctx context.Context, n *Notification) error {
+	body, err := w.body(n)
+	if err != nil {
+		return fmt.Errorf("webhook %v: %w", redactURL(w.URL), err)
+	}
+	retries, backoff := w.Retries, time.Duration(w.RetryBackoff)
+	if retries == 0 {
+		retries = DefaultWebhookRetries
+	}
+	if backoff == 0 {
+		backoff = DefaultWebhookRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = w.send(ctx, body)
+		if err == nil || !retry || attempt >= retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("webhook %v: %w", redactURL(w.URL), errors.Join(err, ctx.Err()))
+		case <-time.After(backoff << attempt):
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("webhook %v: %w", redactURL(w.URL), err)
+	}
+	return nil
+}
+
+// Webhook.send sends a request with the body, and returns an error and if the request should be retried
+func (w *Webhook) send(ctx context.Context, body []byte) (bool, error) {
+	method, timeout, client := w.Method, time.Duration(w.Timeout), w.Client
+	if method == "" {
+		method = http.MethodPost
+	}
+	if timeout == 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, redactURLError(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, redactURLError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) // allow reusing the connection
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected response status %v", resp.Status)
+}
+
+// redactURL returns the URL without the user info, path and query, where webhooks usually have their secrets
+// (e.g. the token in the path of chat webhooks), to print in errors and the effective config
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Redacted
+	}
+	if u.User != nil {
+		u.User = url.User(Redacted)
+	}
+	if u.Path != "" && u.Path != "/" {
+		u.Path, u.RawPath = "/"+Redacted, ""
+	}
+	if u.RawQuery != "" || u.ForceQuery {
+		u.RawQuery = Redacted
+	}
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
+
+// redactURLError redacts the URL in url.Error errors, like the errors of failed requests
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactURL(urlErr.URL)
+	}
+	return err
+}
+
+// tailOutput returns the last maxBytes of the output, with a marker line of the truncated bytes.
+// A negative maxBytes means no limit.
+func tailOutput(output string, maxBytes int) string {
+	if maxBytes < 0 || len(output) <= maxBytes {
+		return output
+	}
+	dropped := len(output) - maxBytes
+	return strings.TrimPrefix(string(truncateMarker(int64(dropped))), "\n") + output[dropped:]
+}